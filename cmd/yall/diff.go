@@ -0,0 +1,172 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// contextLines is the number of unchanged lines shown around changes.
+const contextLines = 3
+
+// maxTable is the largest table of common subsequence lengths that
+// changes builds.  Beyond it, the changed lines are all shown as removed
+// and added again.
+const maxTable = 1 << 22
+
+// edit is one line of a diff: op is ' ' for a line of both texts, '-' for
+// a line of the first only and '+' for a line of the second only.
+type edit struct {
+	op   byte
+	line string
+}
+
+// lines splits b into lines, each keeping its newline.
+func lines(b []byte) []string {
+	ls := []string{}
+	for s := string(b); s != ""; {
+		i := strings.IndexByte(s, '\n') + 1
+		if i == 0 {
+			i = len(s)
+		}
+		ls = append(ls, s[:i])
+		s = s[i:]
+	}
+	return ls
+}
+
+// edits returns the edits turning a into b.
+func edits(a, b []string) []edit {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	es := []edit{}
+	for _, line := range a[:prefix] {
+		es = append(es, edit{' ', line})
+	}
+	es = append(es, changes(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])...)
+	for _, line := range a[len(a)-suffix:] {
+		es = append(es, edit{' ', line})
+	}
+	return es
+}
+
+// changes returns the edits turning a into b that keep the longest common
+// subsequence of their lines.
+func changes(a, b []string) []edit {
+	n, m := len(a), len(b)
+	es := []edit{}
+	i, j := 0, 0
+	if (n+1)*(m+1) <= maxTable {
+		// length[i*(m+1)+j] is the length of the longest common subsequence
+		// of a[i:] and b[j:].
+		length := make([]int32, (n+1)*(m+1))
+		for i := n - 1; 0 <= i; i-- {
+			for j := m - 1; 0 <= j; j-- {
+				switch {
+				case a[i] == b[j]:
+					length[i*(m+1)+j] = length[(i+1)*(m+1)+j+1] + 1
+				case length[i*(m+1)+j+1] < length[(i+1)*(m+1)+j]:
+					length[i*(m+1)+j] = length[(i+1)*(m+1)+j]
+				default:
+					length[i*(m+1)+j] = length[i*(m+1)+j+1]
+				}
+			}
+		}
+		for i < n && j < m {
+			switch {
+			case a[i] == b[j]:
+				es = append(es, edit{' ', a[i]})
+				i++
+				j++
+			case length[i*(m+1)+j+1] <= length[(i+1)*(m+1)+j]:
+				es = append(es, edit{'-', a[i]})
+				i++
+			default:
+				es = append(es, edit{'+', b[j]})
+				j++
+			}
+		}
+	}
+	for ; i < n; i++ {
+		es = append(es, edit{'-', a[i]})
+	}
+	for ; j < m; j++ {
+		es = append(es, edit{'+', b[j]})
+	}
+	return es
+}
+
+// span returns the range of count lines starting after the first lines
+// of a text, as written in the header of a hunk.
+func span(first, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", first)
+	case 1:
+		return fmt.Sprintf("%d", first+1)
+	}
+	return fmt.Sprintf("%d,%d", first+1, count)
+}
+
+// diff returns the differences between a and b in the unified format of
+// diff -u, or nothing if they are the same.
+func diff(filename string, a, b []byte) []byte {
+	es := edits(lines(a), lines(b))
+	// x[i] and y[i] are the numbers of the lines of a and b before es[i].
+	x, y := make([]int, len(es)+1), make([]int, len(es)+1)
+	for i, e := range es {
+		x[i+1], y[i+1] = x[i], y[i]
+		if e.op != '+' {
+			x[i+1]++
+		}
+		if e.op != '-' {
+			y[i+1]++
+		}
+	}
+	var out bytes.Buffer
+	for i := 0; i < len(es); {
+		if es[i].op == ' ' {
+			i++
+			continue
+		}
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s.orig\n+++ %s\n", filename, filename)
+		}
+		// The hunk goes on until more unchanged lines follow its last change
+		// than the context of two hunks.
+		end := i + 1
+		for j := end; j < len(es) && j-end <= 2*contextLines; j++ {
+			if es[j].op != ' ' {
+				end = j + 1
+			}
+		}
+		start := i - contextLines
+		if start < 0 {
+			start = 0
+		}
+		stop := end + contextLines
+		if len(es) < stop {
+			stop = len(es)
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", span(x[start], x[stop]-x[start]), span(y[start], y[stop]-y[start]))
+		for _, e := range es[start:stop] {
+			out.WriteByte(e.op)
+			out.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				out.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = stop
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,31 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+)
+
+type diffTestCase struct {
+	a, b   string
+	output string
+}
+
+var diffTestCases = []diffTestCase{
+	diffTestCase{"a\nb\n", "a\nb\n", ""},
+	diffTestCase{"", "x\n", "--- x.orig\n+++ x\n@@ -0,0 +1 @@\n+x\n"},
+	diffTestCase{"a\nb\nc\nd\ne\nf\ng\nh\n", "a\nB\nc\nd\ne\nf\ng\nH\n",
+		"--- x.orig\n+++ x\n@@ -1,8 +1,8 @@\n a\n-b\n+B\n c\n d\n e\n f\n g\n-h\n+H\n"},
+	diffTestCase{"a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\n", "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nL\nm",
+		"--- x.orig\n+++ x\n@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n@@ -9,5 +9,5 @@\n i\n j\n k\n-l\n-m\n+L\n+m\n\\ No newline at end of file\n"},
+}
+
+func TestDiff(t *testing.T) {
+	for _, tc := range diffTestCases {
+		if output := string(diff("x", []byte(tc.a), []byte(tc.b))); output != tc.output {
+			t.Errorf("%q %q: Received [[%v]] when expecting [[%v]]", tc.a, tc.b, output, tc.output)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/yaraki/yall"
+)
+
+const fmtUsage = `usage: yall fmt [-w | -d] [files...]
+
+Formats yall source files canonically.  Without files, formats the standard
+input.  With -d, prints a diff of the changes and exits with status 1 if any
+file is not formatted.
+`
+
+// formatFile formats one file, or the standard input when filename is
+// empty, and reports whether it was already formatted.
+func formatFile(filename string, write, showDiff bool) (bool, error) {
+	var src []byte
+	var err error
+	if filename == "" {
+		src, err = ioutil.ReadAll(os.Stdin)
+		filename = "<standard input>"
+	} else {
+		src, err = ioutil.ReadFile(filename)
+	}
+	if err != nil {
+		return false, err
+	}
+	formatted, err := yall.FormatSource(bytes.NewReader(src), yall.DefaultWidth)
+	if err != nil {
+		return false, fmt.Errorf("%s: %v", filename, err)
+	}
+	unchanged := bytes.Equal(src, formatted)
+	switch {
+	case showDiff:
+		if !unchanged {
+			os.Stdout.Write(diff(filename, src, formatted))
+		}
+	case write:
+		if !unchanged {
+			return false, ioutil.WriteFile(filename, formatted, 0644)
+		}
+	default:
+		os.Stdout.Write(formatted)
+	}
+	return unchanged, nil
+}
+
+func formatFiles(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "write result to the source file instead of stdout")
+	showDiff := flags.Bool("d", false, "display diffs instead of rewriting files")
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, fmtUsage)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	filenames := flags.Args()
+	if len(filenames) == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "yall fmt: cannot use -w with standard input")
+			return 2
+		}
+		filenames = []string{""}
+	}
+	status := 0
+	for _, filename := range filenames {
+		unchanged, err := formatFile(filename, *write, *showDiff)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 2
+		} else if *showDiff && !unchanged && status == 0 {
+			status = 1
+		}
+	}
+	return status
+}
@@ -9,55 +9,153 @@ import (
 	"flag"
 	"fmt"
 	"github.com/yaraki/yall"
+	_ "github.com/yaraki/yall/modules/regexp"
+	_ "github.com/yaraki/yall/modules/time"
 	"os"
+	"strconv"
+	"strings"
 )
 
-func prompt() {
-	fmt.Print("yall> ")
+func prompt(continued bool) {
+	if continued {
+		fmt.Print("....> ")
+	} else {
+		fmt.Print("yall> ")
+	}
 }
 
-func repl() {
-	env := yall.NewEnv()
+// terminalWidth returns the width of the terminal as told by $COLUMNS.
+func terminalWidth() int {
+	if width, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && 0 < width {
+		return width
+	}
+	return yall.DefaultWidth
+}
+
+func repl(env *yall.Env) {
+	reader := bufio.NewReader(os.Stdin)
+	env.SetInput(reader)
+	source := ""
 	for {
-		prompt()
-		reader := bufio.NewReader(os.Stdin)
-		line, _, err := reader.ReadLine()
+		prompt(source != "")
+		line, err := reader.ReadString('\n')
+		source += line
 		if nil != err {
+			fmt.Println()
 			return
 		}
+		if evalSource(env, source) {
+			source = ""
+		}
+	}
+}
+
+// evalSource evaluates the forms in source and prints their values.  It
+// reports false without evaluating anything if source is incomplete.
+// Errors, including those raised by reader macros, are printed.
+func evalSource(env *yall.Env, source string) (complete bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println(r)
+			complete = true
+		}
+	}()
+	exprs, err := env.NewReader(strings.NewReader(source)).ReadAll()
+	if err == yall.ErrIncomplete {
+		return false
+	}
+	if nil != err {
+		fmt.Println(err)
+		return true
+	}
+	for _, expr := range exprs {
 		func() {
 			defer func() {
 				if r := recover(); r != nil {
 					fmt.Println(r)
 				}
 			}()
-			if result := env.EvalString(string(line)); result != nil {
-				fmt.Println(result.String())
-			}
+			fmt.Println(yall.Pretty(env.Eval(expr), terminalWidth()))
 		}()
 	}
-	fmt.Println()
+	return true
 }
 
-func loadFiles() {
-	env := yall.NewEnv()
-	for i := 0; i < flag.NArg(); i++ {
-		file, err := os.Open(flag.Arg(i))
-		if file == nil {
+func loadFiles(env *yall.Env, filenames []string) {
+	for _, filename := range filenames {
+		if err := env.LoadFile(filename); err != nil {
 			fmt.Fprintf(os.Stderr, "Can't open %s: error %s\n",
-				flag.Arg(i), err)
+				filename, err)
 			os.Exit(1)
 		}
-		defer file.Close()
-		env.Load(file)
 	}
 }
 
+// newEnv returns the Env to run in, restored from image if it is given.
+func newEnv(image string) *yall.Env {
+	if image == "" {
+		return yall.NewEnv()
+	}
+	env, err := yall.NewEnvWithOptions(yall.EnvOptions{NoPrelude: true})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	file, err := os.Open(image)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer file.Close()
+	if err := env.LoadImage(file); err != nil {
+		fmt.Fprintf(os.Stderr, "Can't load image %s: %s\n", image, err)
+		os.Exit(1)
+	}
+	return env
+}
+
+// saveImage loads the files named by args[1:] and saves the resulting
+// environment to the image args[0].
+func saveImage(env *yall.Env, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: yall save-image out.img [file...]")
+		return 2
+	}
+	loadFiles(env, args[1:])
+	file, err := os.Create(args[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	err = env.SaveImage(file)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can't save image %s: %s\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
 func main() {
+	image := flag.String("image", "", "start from the environment saved in `file`")
+	fasl := flag.Bool("fasl", false, "keep compiled .fasl files next to the files loaded")
+	faslDir := flag.String("fasl-dir", "", "keep compiled .fasl files in `dir`")
 	flag.Parse()
+	if flag.Arg(0) == "fmt" {
+		os.Exit(formatFiles(flag.Args()[1:]))
+	}
+	env := newEnv(*image)
+	if *fasl || *faslDir != "" {
+		env.EnableFaslCache(*faslDir)
+	}
+	if flag.Arg(0) == "save-image" {
+		os.Exit(saveImage(env, flag.Args()[1:]))
+	}
 	if flag.NArg() == 0 {
-		repl()
+		repl(env)
 	} else {
-		loadFiles()
+		loadFiles(env, flag.Args())
 	}
 }
@@ -0,0 +1,216 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+)
+
+type Channel struct {
+	ch chan Expr
+}
+
+func NewChannel(size int) *Channel {
+	return &Channel{make(chan Expr, size)}
+}
+
+func (channel *Channel) String() string {
+	return "<channel>"
+}
+
+// Send sends expr to channel.  Sending to a closed channel raises an error
+// instead of crashing the interpreter.
+func (channel *Channel) Send(expr Expr) {
+	channel.send(context.Background(), expr)
+}
+
+// send sends expr to channel, or raises an error if ctx is done first.
+func (channel *Channel) send(ctx context.Context, expr Expr) {
+	sent := func() bool {
+		defer func() {
+			if r := recover(); r != nil {
+				panic(NewRuntimeError("Send on a closed channel"))
+			}
+		}()
+		select {
+		case channel.ch <- expr:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}()
+	if !sent {
+		panic(contextError(ctx))
+	}
+}
+
+// Receive receives a value from channel, or returns EOF if channel is
+// closed.
+func (channel *Channel) Receive() Expr {
+	return channel.receive(context.Background())
+}
+
+// receive is Receive, but raises an error if ctx is done first.
+func (channel *Channel) receive(ctx context.Context) Expr {
+	select {
+	case expr, ok := <-channel.ch:
+		if ok {
+			return expr
+		}
+		return EOF
+	case <-ctx.Done():
+		panic(contextError(ctx))
+	}
+}
+
+func (channel *Channel) Close() {
+	defer func() {
+		if r := recover(); r != nil {
+			panic(NewRuntimeError("Close of a closed channel"))
+		}
+	}()
+	close(channel.ch)
+}
+
+func channelArg(name string, expr Expr) *Channel {
+	if channel, ok := expr.(*Channel); ok {
+		return channel
+	}
+	panic(NewRuntimeError(name + " requires a channel, but got " + printable(expr)))
+}
+
+// concurrencyFunctions are the builtin functions that work on channels.
+// Waiting on a channel stops when the evaluation is cancelled.
+var concurrencyFunctions = map[string]func(*Env, *Cell) Expr{
+
+	"make-chan": func(env *Env, args *Cell) Expr {
+		size := 0
+		if Empty != args {
+			i, ok := args.Car().(*Integer)
+			if !ok || i.value < 0 {
+				panic(NewRuntimeError("make-chan requires a non-negative size, but got " + printable(args.Car())))
+			}
+			size = i.value
+		}
+		env.allocate(int64(size) * slotSize)
+		return NewChannel(size)
+	},
+
+	"chan-send": func(env *Env, args *Cell) Expr {
+		channelArg("chan-send", args.Car()).send(env.currentContext(), args.Cadr())
+		return True
+	},
+
+	"chan-recv": func(env *Env, args *Cell) Expr {
+		return channelArg("chan-recv", args.Car()).receive(env.currentContext())
+	},
+
+	"chan-close": func(env *Env, args *Cell) Expr {
+		channelArg("chan-close", args.Car()).Close()
+		return True
+	},
+}
+
+// selectClause is a clause of select, with the variable to bind to the
+// received value, if any, and the body to evaluate when it is chosen.
+type selectClause struct {
+	symbol *Symbol
+	body   *Cell
+}
+
+// concurrencySpecialForms are the special forms that run code concurrently.
+var concurrencySpecialForms = map[string]func(*Env, *Cell) Expr{
+
+	// (go expr) evaluates expr in a new goroutine.  An error in it, or any
+	// other panic, is written to the current output port.
+	"go": func(env *Env, args *Cell) Expr {
+		derived := env.Derive()
+		go func() {
+			defer func() {
+				if r := recover(); r != nil {
+					err, ok := r.(error)
+					if !ok {
+						err = NewRuntimeError(fmt.Sprint(r))
+					}
+					derived.currentOutput().writeError(err)
+				}
+			}()
+			derived.Begin(args)
+		}()
+		return True
+	},
+
+	// (select clause...) waits until one of the clauses can proceed and
+	// evaluates its body.  A clause is one of
+	//
+	//	(recv channel (var) body...)   receive a value and bind it to var
+	//	(send channel value body...)   send value
+	//	(default body...)              when no other clause can proceed
+	"select": func(env *Env, args *Cell) Expr {
+		cases := []reflect.SelectCase{}
+		clauses := []selectClause{}
+		for c := args; c != Empty; c = c.cdr {
+			clause, ok := c.car.(*Cell)
+			if !ok || Empty == clause {
+				panic(NewRuntimeError("Invalid select clause: " + printable(c.car)))
+			}
+			kind, _ := clause.car.(*Symbol)
+			switch {
+			case kind != nil && kind.name == "recv":
+				channel := channelArg("select", env.Eval(clause.Cadr()))
+				binding, ok := clause.Caddr().(*Cell)
+				if !ok || Empty == binding {
+					panic(NewRuntimeError("select requires (var) in a recv clause"))
+				}
+				symbol, ok := binding.car.(*Symbol)
+				if !ok {
+					panic(NewRuntimeError("select requires (var) in a recv clause"))
+				}
+				cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(channel.ch)})
+				clauses = append(clauses, selectClause{symbol, clause.cdr.cdr.cdr})
+			case kind != nil && kind.name == "send":
+				channel := channelArg("select", env.Eval(clause.Cadr()))
+				value := env.Eval(clause.Caddr())
+				cases = append(cases, reflect.SelectCase{Dir: reflect.SelectSend,
+					Chan: reflect.ValueOf(channel.ch), Send: reflect.ValueOf(&value).Elem()})
+				clauses = append(clauses, selectClause{nil, clause.cdr.cdr.cdr})
+			case kind != nil && kind.name == "default":
+				cases = append(cases, reflect.SelectCase{Dir: reflect.SelectDefault})
+				clauses = append(clauses, selectClause{nil, clause.cdr})
+			default:
+				panic(NewRuntimeError("Invalid select clause: " + clause.String()))
+			}
+		}
+		// The last case is the evaluation being cancelled.
+		ctx := env.currentContext()
+		cases = append(cases, reflect.SelectCase{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())})
+		var chosen int
+		var received reflect.Value
+		var ok bool
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					panic(NewRuntimeError("Send on a closed channel"))
+				}
+			}()
+			chosen, received, ok = reflect.Select(cases)
+		}()
+		if chosen == len(clauses) {
+			panic(contextError(ctx))
+		}
+		clause := clauses[chosen]
+		derived := env.Derive()
+		if clause.symbol != nil {
+			var value Expr = EOF
+			if ok {
+				value = received.Interface().(Expr)
+			}
+			derived.Intern(clause.symbol, value)
+		}
+		return derived.Begin(clause.body)
+	},
+}
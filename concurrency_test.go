@@ -0,0 +1,178 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPipeline(t *testing.T) {
+	env := NewEnv()
+	env.EvalString(`
+(def nums (make-chan))
+(def squares (make-chan 2))
+(defn (produce i n)
+  (if (= i n)
+      (chan-close nums)
+      ((fn () (chan-send nums i) (produce (+ i 1) n)))))
+(defn (square)
+  ((fn (v)
+     (if (eof-object? v)
+         (chan-close squares)
+         ((fn () (chan-send squares (* v v)) (square)))))
+   (chan-recv nums)))
+(defn (collect acc)
+  ((fn (v) (if (eof-object? v) acc (collect (+ acc v)))) (chan-recv squares)))
+(go (produce 1 5))
+(go (square))`)
+	if expr := env.EvalString("(collect 0)"); expr.String() != "30" {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", expr, "30")
+	}
+}
+
+func TestSelect(t *testing.T) {
+	env := NewEnv()
+	env.EvalString("(def c (make-chan 1))")
+	for _, tc := range []evalTestCase{
+		evalTestCase{"(select (recv c (v) v) (default 'empty))", "empty"},
+		evalTestCase{"(select (send c 42 'sent) (default 'full))", "sent"},
+		evalTestCase{"(select (send c 43 'sent) (default 'full))", "full"},
+		evalTestCase{"(select (recv c (v) (+ v 1)))", "43"},
+		evalTestCase{"(type-of c)", "<channel>"},
+		evalTestCase{"(chan-close c)", "#t"},
+		evalTestCase{"(select (recv c (v) v))", "#<eof>"},
+		evalTestCase{"(try (chan-send c 1) error-message)", "\"Send on a closed channel\""},
+		evalTestCase{"(try (select (send c 1)) error-message)", "\"Send on a closed channel\""},
+	} {
+		if expr := env.EvalString(tc.input); expr.String() != tc.output {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+		}
+	}
+}
+
+type notifyingWriter chan string
+
+func (w notifyingWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestGoError(t *testing.T) {
+	env := NewEnv()
+	w := make(notifyingWriter, 1)
+	env.SetOutput(w)
+	env.EvalString("(go (car 1))")
+	select {
+	case message := <-w:
+		if message != "*** ERROR: pair required, but got 1\n" {
+			t.Errorf("Received [[%v]]", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("The error in the goroutine was not reported")
+	}
+	env.internEnvFunction("boom", func(env *Env, args *Cell) Expr {
+		panic("boom")
+	})
+	env.EvalString("(go (boom))")
+	select {
+	case message := <-w:
+		if message != "*** ERROR: boom\n" {
+			t.Errorf("Received [[%v]]", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("The panic in the goroutine was not reported")
+	}
+}
+
+func TestConcurrentEval(t *testing.T) {
+	env := NewEnv()
+	env.EvalString("(def counter 0) (def total 0)")
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := "v" + strconv.Itoa(i)
+			env.EvalString("(def " + name + " " + strconv.Itoa(i) + ")")
+			for j := 0; j < 100; j++ {
+				env.EvalString("(inc! counter)")
+				env.EvalString("(swap! total + " + name + ")")
+				env.EvalString("(with-output-to-string (fn () (display " + name + ")))")
+			}
+			env.EvalString("(set! " + name + " (+ " + name + " 1))")
+		}(i)
+	}
+	wg.Wait()
+	for _, tc := range []evalTestCase{
+		evalTestCase{"counter", "1600"},
+		evalTestCase{"total", "12000"},
+		evalTestCase{"v15", "16"},
+		evalTestCase{"(try (set! undefined 1) error-message)", "\"Unbound variable: undefined\""},
+	} {
+		if expr := env.EvalString(tc.input); expr.String() != tc.output {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+		}
+	}
+}
+
+func TestConcurrentOutput(t *testing.T) {
+	env := NewEnv()
+	output := new(bytes.Buffer)
+	env.SetOutput(output)
+	env.EvalString(`(defn (say x) (display x) (display x))`)
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := strconv.Itoa(i)
+			for j := 0; j < 50; j++ {
+				expected := "\"" + name + name + "\""
+				if expr := env.EvalString("(with-output-to-string (fn () (say " + name + ")))"); expr.String() != expected {
+					t.Errorf("Received [[%v]] when expecting [[%v]]", expr, expected)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	env.EvalString(`(display "final")`)
+	if output.String() != "final" {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", output, "final")
+	}
+}
+
+func TestOverlappingOutput(t *testing.T) {
+	env := NewEnv()
+	output := new(bytes.Buffer)
+	env.SetOutput(output)
+	env.EvalString("(def started (make-chan)) (def release-a (make-chan)) (def release-b (make-chan))")
+	capture := func(name string, release string, result chan Expr) {
+		result <- env.EvalString(`(with-output-to-string (fn ()
+  (display "` + name + `") (chan-send started 1) (chan-recv ` + release + `) (display "` + name + `")))`)
+	}
+	a, b := make(chan Expr), make(chan Expr)
+	go capture("a", "release-a", a)
+	env.EvalString("(chan-recv started)")
+	go capture("b", "release-b", b)
+	env.EvalString("(chan-recv started)")
+	// a finishes while b is still capturing.
+	env.EvalString("(chan-send release-a 1)")
+	if expr := <-a; expr.String() != "\"aa\"" {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", expr, "\"aa\"")
+	}
+	env.EvalString("(chan-send release-b 1)")
+	if expr := <-b; expr.String() != "\"bb\"" {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", expr, "\"bb\"")
+	}
+	env.EvalString(`(display "final")`)
+	if output.String() != "final" {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", output, "final")
+	}
+}
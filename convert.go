@@ -0,0 +1,389 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Go values convert to expressions as follows:
+//
+//	bool                 True or False
+//	int and uint types   Integer
+//	float types          Float
+//	string               String
+//	slice, array         a list
+//	map                  (object (key value)...), as JSON objects are read
+//	struct               an alist, a list of (key value) lists
+//	nil                  Empty
+//
+// The keys of struct alists are strings naming the exported fields.  A
+// field tagged `yall:"name"` uses name instead, and `yall:"-"` is skipped.
+// Pointers and interfaces convert to what they point to, and expressions
+// convert to themselves.  A value that contains itself cannot be
+// converted.
+
+var exprType = reflect.TypeOf((*Expr)(nil)).Elem()
+
+// fieldName returns the key of a struct field in an alist, and false if the
+// field is not converted.
+func fieldName(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" { // unexported
+		return "", false
+	}
+	tag := field.Tag.Get("yall")
+	if tag == "-" {
+		return "", false
+	}
+	if name := strings.Split(tag, ",")[0]; name != "" {
+		return name, true
+	}
+	return field.Name, true
+}
+
+func listOf(exprs []Expr) *Cell {
+	list := Empty
+	for i := len(exprs) - 1; 0 <= i; i-- {
+		list = NewCell(exprs[i], list)
+	}
+	return list
+}
+
+func entry(key Expr, value Expr) *Cell {
+	return NewCell(key, NewCell(value, Empty))
+}
+
+// visit is a pointer, map or slice being converted by fromValue, which
+// finds cycles by meeting one again inside itself.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+// FromGo converts a Go value into an expression.
+func FromGo(v interface{}) (Expr, error) {
+	if v == nil {
+		return Empty, nil
+	}
+	return fromValue(reflect.ValueOf(v))
+}
+
+func fromValue(v reflect.Value) (Expr, error) {
+	return fromValueVisiting(v, make(map[visit]bool))
+}
+
+func fromValueVisiting(v reflect.Value, visiting map[visit]bool) (Expr, error) {
+	if v.Type().Implements(exprType) {
+		if (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) && v.IsNil() {
+			return Empty, nil
+		}
+		return v.Interface().(Expr), nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			return True, nil
+		}
+		return False, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return NewInteger(int(v.Int())), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if uint64(math.MaxInt) < v.Uint() {
+			return nil, fmt.Errorf("Cannot convert %v to an integer", v.Uint())
+		}
+		return NewInteger(int(v.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return NewFloat(v.Float()), nil
+	case reflect.String:
+		return NewString(v.String()), nil
+	case reflect.Ptr, reflect.Map, reflect.Slice:
+		if !v.IsNil() {
+			current := visit{v.Pointer(), v.Type(), 0}
+			if v.Kind() == reflect.Slice {
+				current.len = v.Len()
+			}
+			if visiting[current] {
+				return nil, fmt.Errorf("Cannot convert a cyclic value of type %v", v.Type())
+			}
+			visiting[current] = true
+			defer delete(visiting, current)
+		}
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return Empty, nil
+		}
+		return fromValueVisiting(v.Elem(), visiting)
+	case reflect.Slice, reflect.Array:
+		exprs := make([]Expr, v.Len())
+		for i := range exprs {
+			expr, err := fromValueVisiting(v.Index(i), visiting)
+			if err != nil {
+				return nil, err
+			}
+			exprs[i] = expr
+		}
+		return listOf(exprs), nil
+	case reflect.Map:
+		keys := v.MapKeys()
+		exprs := make([]Expr, len(keys))
+		for i, key := range keys {
+			k, err := fromValueVisiting(key, visiting)
+			if err != nil {
+				return nil, err
+			}
+			value, err := fromValueVisiting(v.MapIndex(key), visiting)
+			if err != nil {
+				return nil, err
+			}
+			exprs[i] = entry(k, value)
+		}
+		sort.Slice(exprs, func(i, j int) bool {
+			return exprs[i].(*Cell).car.String() < exprs[j].(*Cell).car.String()
+		})
+		return NewCell(jsonObject, listOf(exprs)), nil
+	case reflect.Struct:
+		exprs := []Expr{}
+		for i := 0; i < v.NumField(); i++ {
+			name, ok := fieldName(v.Type().Field(i))
+			if !ok {
+				continue
+			}
+			value, err := fromValueVisiting(v.Field(i), visiting)
+			if err != nil {
+				return nil, err
+			}
+			exprs = append(exprs, entry(NewString(name), value))
+		}
+		return listOf(exprs), nil
+	}
+	return nil, fmt.Errorf("Cannot convert a value of type %v to an expression", v.Type())
+}
+
+// ToGo stores the Go value of expr in the value pointed to by target,
+// converting it as FromGo does in reverse.  Maps and structs can be given
+// either as alists or as (object ...) expressions.  If target points to an
+// empty interface, objects become map[string]interface{}, other lists
+// []interface{} and other values their natural Go types.
+func ToGo(expr Expr, target interface{}) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("ToGo requires a non-nil pointer, but got %T", target)
+	}
+	return toValue(expr, v.Elem())
+}
+
+// entries returns the (key value) entries of list, which is an alist or an
+// (object ...) expression.
+func entries(list *Cell) *Cell {
+	if symbol, ok := list.Car().(*Symbol); ok && symbol.name == jsonObject.name {
+		return list.cdr
+	}
+	return list
+}
+
+func cannotConvert(expr Expr, t reflect.Type) error {
+	return fmt.Errorf("Cannot convert %v to %v", printable(expr), t)
+}
+
+// naturalValue returns the Go value that expr converts to when the target
+// type is an empty interface.
+func naturalValue(expr Expr) (interface{}, error) {
+	switch e := expr.(type) {
+	case *Bool:
+		return e.value, nil
+	case *Integer:
+		return e.value, nil
+	case *Float:
+		return e.value, nil
+	case *String:
+		return e.value, nil
+	case *Symbol:
+		return e.name, nil
+	case *Cell:
+		if Empty == e {
+			return nil, nil
+		}
+		if object, ok, err := jsonObjectEntries(e); ok && err == nil {
+			values := make(map[string]interface{})
+			for c := object; c != Empty; c = c.cdr {
+				key, _ := alistKey(c.car)
+				value, err := naturalValue(c.car.(*Cell).Cadr())
+				if err != nil {
+					return nil, err
+				}
+				values[key] = value
+			}
+			return values, nil
+		}
+		values := []interface{}{}
+		for c := e; c != Empty; c = c.cdr {
+			value, err := naturalValue(c.car)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+		}
+		return values, nil
+	case *GoValue:
+		return e.value.Interface(), nil
+	}
+	return expr, nil
+}
+
+func toValue(expr Expr, v reflect.Value) error {
+	t := v.Type()
+	if expr == nil {
+		return fmt.Errorf("Cannot convert nil to %v", t)
+	}
+	if reflect.TypeOf(expr).AssignableTo(t) && (t.Kind() != reflect.Interface || t.NumMethod() != 0) {
+		v.Set(reflect.ValueOf(expr))
+		return nil
+	}
+	if g, ok := expr.(*GoValue); ok && g.value.IsValid() && g.value.Type().AssignableTo(t) {
+		v.Set(g.value)
+		return nil
+	}
+	switch t.Kind() {
+	case reflect.Interface:
+		if t.NumMethod() != 0 {
+			return cannotConvert(expr, t)
+		}
+		value, err := naturalValue(expr)
+		if err != nil {
+			return err
+		}
+		if value == nil {
+			v.Set(reflect.Zero(t))
+		} else {
+			v.Set(reflect.ValueOf(value))
+		}
+		return nil
+	case reflect.Bool:
+		if b, ok := expr.(*Bool); ok {
+			v.SetBool(b.value)
+			return nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if i, ok := expr.(*Integer); ok && !v.OverflowInt(int64(i.value)) {
+			v.SetInt(int64(i.value))
+			return nil
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if i, ok := expr.(*Integer); ok && 0 <= i.value && !v.OverflowUint(uint64(i.value)) {
+			v.SetUint(uint64(i.value))
+			return nil
+		}
+	case reflect.Float32, reflect.Float64:
+		switch n := expr.(type) {
+		case *Integer:
+			v.SetFloat(float64(n.value))
+			return nil
+		case *Float:
+			v.SetFloat(n.value)
+			return nil
+		}
+	case reflect.String:
+		switch s := expr.(type) {
+		case *String:
+			v.SetString(s.value)
+			return nil
+		case *Symbol:
+			v.SetString(s.name)
+			return nil
+		}
+	case reflect.Ptr:
+		if Empty == expr {
+			v.Set(reflect.Zero(t))
+			return nil
+		}
+		p := reflect.New(t.Elem())
+		if err := toValue(expr, p.Elem()); err != nil {
+			return err
+		}
+		v.Set(p)
+		return nil
+	case reflect.Slice:
+		if list, ok := expr.(*Cell); ok {
+			if Empty == list {
+				v.Set(reflect.Zero(t))
+				return nil
+			}
+			s := reflect.MakeSlice(t, 0, 0)
+			for c := list; c != Empty; c = c.cdr {
+				elem := reflect.New(t.Elem()).Elem()
+				if err := toValue(c.car, elem); err != nil {
+					return err
+				}
+				s = reflect.Append(s, elem)
+			}
+			v.Set(s)
+			return nil
+		}
+	case reflect.Array:
+		if list, ok := expr.(*Cell); ok {
+			i := 0
+			for c := list; c != Empty; c = c.cdr {
+				if v.Len() <= i {
+					return fmt.Errorf("Cannot convert %v to %v: too many elements", list, t)
+				}
+				if err := toValue(c.car, v.Index(i)); err != nil {
+					return err
+				}
+				i++
+			}
+			return nil
+		}
+	case reflect.Map:
+		if list, ok := expr.(*Cell); ok {
+			m := reflect.MakeMap(t)
+			for c := entries(list); c != Empty; c = c.cdr {
+				e, ok := c.car.(*Cell)
+				if !ok || Empty == e || Empty == e.cdr {
+					return fmt.Errorf("Cannot convert %v to %v: not an alist", list, t)
+				}
+				key := reflect.New(t.Key()).Elem()
+				if err := toValue(e.car, key); err != nil {
+					return err
+				}
+				value := reflect.New(t.Elem()).Elem()
+				if err := toValue(e.Cadr(), value); err != nil {
+					return err
+				}
+				m.SetMapIndex(key, value)
+			}
+			v.Set(m)
+			return nil
+		}
+	case reflect.Struct:
+		if list, ok := expr.(*Cell); ok {
+			fields := make(map[string]int)
+			for i := 0; i < t.NumField(); i++ {
+				if name, ok := fieldName(t.Field(i)); ok {
+					fields[name] = i
+				}
+			}
+			for c := entries(list); c != Empty; c = c.cdr {
+				key, ok := alistKey(c.car)
+				if !ok {
+					return fmt.Errorf("Cannot convert %v to %v: not an alist", list, t)
+				}
+				if i, ok := fields[key]; ok {
+					if err := toValue(c.car.(*Cell).Cadr(), v.Field(i)); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		}
+	}
+	return cannotConvert(expr, t)
+}
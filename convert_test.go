@@ -0,0 +1,125 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"reflect"
+	"testing"
+)
+
+type convertTestUser struct {
+	Name    string `yall:"name"`
+	Age     int
+	Tags    []string
+	Score   *float64
+	Secret  string `yall:"-"`
+	private int
+}
+
+type fromGoTestCase struct {
+	input  interface{}
+	output string
+}
+
+var fromGoTestCases = []fromGoTestCase{
+	fromGoTestCase{nil, "()"},
+	fromGoTestCase{true, "#t"},
+	fromGoTestCase{int8(-3), "-3"},
+	fromGoTestCase{uint(3), "3"},
+	fromGoTestCase{1.5, "1.5"},
+	fromGoTestCase{"a\"b", "\"a\\\"b\""},
+	fromGoTestCase{[]int{1, 2}, "(1 2)"},
+	fromGoTestCase{[2]bool{true, false}, "(#t #f)"},
+	fromGoTestCase{map[string]int{"b": 2, "a": 1}, "(object (\"a\" 1) (\"b\" 2))"},
+	fromGoTestCase{convertTestUser{Name: "x", Age: 3, Secret: "s"}, "((\"name\" \"x\") (\"Age\" 3) (\"Tags\" ()) (\"Score\" ()))"},
+	fromGoTestCase{NewSymbol("sym"), "sym"},
+	fromGoTestCase{[]Expr{NewSymbol("a"), nil}, "(a ())"},
+}
+
+func TestFromGo(t *testing.T) {
+	for _, tc := range fromGoTestCases {
+		expr, err := FromGo(tc.input)
+		if err != nil {
+			t.Errorf("input: [[%v]], ERROR: [[%v]]", tc.input, err)
+		} else if expr.String() != tc.output {
+			t.Errorf("expected: [[%v]], received: [[%v]]", tc.output, expr)
+		}
+	}
+	if _, err := FromGo(make(chan int)); err == nil {
+		t.Errorf("expected an error for a channel")
+	}
+	type node struct {
+		Next *node
+	}
+	cyclic := &node{}
+	cyclic.Next = cyclic
+	if _, err := FromGo(cyclic); err == nil || err.Error() != "Cannot convert a cyclic value of type *yall.node" {
+		t.Errorf("expected an error for a cyclic value, received: [[%v]]", err)
+	}
+	shared := &node{}
+	if expr, err := FromGo([]*node{shared, shared}); err != nil || expr.String() != "(((\"Next\" ())) ((\"Next\" ())))" {
+		t.Errorf("received: [[%v]], ERROR: [[%v]]", expr, err)
+	}
+}
+
+func TestToGo(t *testing.T) {
+	env := NewEnv()
+	var user convertTestUser
+	expr := env.EvalString("'((\"name\" \"x\") (Age 3) (\"Tags\" (a \"b\")) (\"Score\" 2) (\"Secret\" \"s\"))")
+	if err := ToGo(expr, &user); err != nil {
+		t.Fatalf("ERROR: [[%v]]", err)
+	}
+	score := 2.0
+	expected := convertTestUser{Name: "x", Age: 3, Tags: []string{"a", "b"}, Score: &score}
+	if !reflect.DeepEqual(user, expected) {
+		t.Errorf("expected: [[%v]], received: [[%v]]", expected, user)
+	}
+
+	var m map[string][]int
+	if err := ToGo(env.EvalString("'((\"a\" (1 2)) (b ()))"), &m); err != nil {
+		t.Errorf("ERROR: [[%v]]", err)
+	} else if !reflect.DeepEqual(m, map[string][]int{"a": []int{1, 2}, "b": nil}) {
+		t.Errorf("received: [[%v]]", m)
+	}
+
+	m = nil
+	if err := ToGo(env.EvalString("'(object (\"a\" (1 2)))"), &m); err != nil {
+		t.Errorf("ERROR: [[%v]]", err)
+	} else if !reflect.DeepEqual(m, map[string][]int{"a": []int{1, 2}}) {
+		t.Errorf("received: [[%v]]", m)
+	}
+
+	var value interface{}
+	if err := ToGo(nil, &value); err == nil {
+		t.Errorf("expected an error for nil")
+	}
+	if err := ToGo(env.EvalString("'(object (a 1) (\"b\" (x)))"), &value); err != nil {
+		t.Errorf("ERROR: [[%v]]", err)
+	} else if !reflect.DeepEqual(value, map[string]interface{}{"a": 1, "b": []interface{}{"x"}}) {
+		t.Errorf("received: [[%#v]]", value)
+	}
+	if err := ToGo(env.EvalString("'(1 2.5 \"s\" ())"), &value); err != nil {
+		t.Errorf("ERROR: [[%v]]", err)
+	} else if !reflect.DeepEqual(value, []interface{}{1, 2.5, "s", nil}) {
+		t.Errorf("received: [[%#v]]", value)
+	}
+
+	var s *String
+	if err := ToGo(NewString("raw"), &s); err != nil || s.Value() != "raw" {
+		t.Errorf("received: [[%v]], ERROR: [[%v]]", s, err)
+	}
+
+	var i8 int8
+	if err := ToGo(NewInteger(300), &i8); err == nil {
+		t.Errorf("expected an overflow error")
+	}
+	var n int
+	if err := ToGo(NewString("1"), &n); err == nil {
+		t.Errorf("expected a type error")
+	}
+	if err := ToGo(NewInteger(1), n); err == nil {
+		t.Errorf("expected an error for a non-pointer target")
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+// dynamic is the state of one evaluation that is not lexically scoped,
+// such as the current output port.  Functions defined in yall see the
+// dynamic state of their caller rather than that of the frame they were
+// defined in, so evaluations running at the same time do not see each
+// other's.  It is never modified once made.
+type dynamic struct {
+	// output is the current output port, or nil for that of the global
+	// environment.
+	output *OutputPort
+	// budget limits the evaluation, if it is not nil.
+	budget *budget
+	// loading is the stack of files being loaded.
+	loading []string
+}
+
+// lexical returns the frame that definitions made in env go to.
+func (env *Env) lexical() *Env {
+	for env.overlay {
+		env = env.parent
+	}
+	return env
+}
+
+// withDynamic returns a frame of env whose dynamic state is that of env
+// changed by change.  Definitions made in the frame go to env.
+func (env *Env) withDynamic(change func(*dynamic)) *Env {
+	d := dynamic{}
+	if env.dynamic != nil {
+		d = *env.dynamic
+	}
+	change(&d)
+	return env.lexical().overlayWith(&d)
+}
+
+func (env *Env) overlayWith(d *dynamic) *Env {
+	overlay := new(Env)
+	overlay.values = make(map[string]Expr)
+	overlay.parent = env
+	overlay.table = env.table
+	overlay.dynamic = d
+	overlay.overlay = true
+	return overlay
+}
+
+// global returns the global frame of env with the dynamic state of env.
+func (env *Env) global() *Env {
+	if env.dynamic == nil {
+		return env.root()
+	}
+	return env.root().overlayWith(env.dynamic)
+}
+
+// call applies function to args for env.  A function defined in yall runs
+// with the dynamic state of env.
+func (function *Function) call(env *Env, args *Cell) Expr {
+	if function.closure != nil {
+		return function.closure.callWith(env.dynamic, args)
+	}
+	return function.f(args)
+}
+
+// expand expands the macro call with args for env, as call does.
+func (macro *Macro) expand(env *Env, args *Cell) Expr {
+	if macro.closure != nil {
+		return macro.closure.callWith(env.dynamic, args)
+	}
+	return macro.f(args)
+}
@@ -6,54 +6,149 @@ package yall
 
 import (
 	"bufio"
-	"os"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
 )
 
+// Env is a frame of variable bindings.  Its methods may be called from
+// several goroutines at once.
 type Env struct {
-	values map[string]Expr
-	parent *Env
+	// lock guards values, and the other fields but parent, table, fsys,
+	// builtins, dynamic and overlay in the root frame.  memoryLimit is
+	// accessed atomically instead.
+	lock        sync.RWMutex
+	values      map[string]Expr
+	parent      *Env
+	table       *readtable
+	input       *InputPort
+	output      *OutputPort
+	memoryLimit int64
+	// fsys is the file system load reads from, or nil for the host's.
+	fsys     fs.FS
+	builtins Builtins
+	// modules maps names to the modules defined in the root frame, and
+	// moduleLock is held while a registered module is made.
+	modules    map[string]*Module
+	moduleLock sync.Mutex
+	// loadPaths are the directories added by AddLoadPath, loaded is the
+	// set of files loaded by require and requiring maps the files being
+	// required to channels closed when they are done.
+	loadPaths []string
+	loaded    map[string]bool
+	requiring map[string]chan struct{}
+	// fasl is set if load and require keep compiled files, in faslDir or
+	// next to the source if it is empty.
+	fasl    bool
+	faslDir string
+	// origin is the frame a fork copies the bindings of this frame from
+	// when they are first used, and fork translates them.  A binding
+	// removed from such a frame is kept as nil, so that it is not copied.
+	origin *Env
+	fork   *fork
+	// dynamic is the dynamic state of the evaluation in this frame, or
+	// nil for the defaults.  overlay is set in the frames made only to
+	// change it, whose definitions go to their parent.
+	dynamic *dynamic
+	overlay bool
 }
 
+// NewEnv returns a new Env with every builtin installed.
 func NewEnv() *Env {
-	env := new(Env)
-	env.values = make(map[string]Expr)
-	env.parent = nil
-	env.internVariable("#t", True)
-	env.internVariable("#f", False)
-	for name, form := range specialForms {
-		env.internSpecialForm(name, form)
-	}
-	for name, function := range builtinFunctions {
-		env.internFunction(name, function)
-	}
-	f, err := os.Open(os.Getenv("GOPATH") + "/src/github.com/yaraki/yall/lisp/sys.yall")
-	if err != nil {
-		panic(NewRuntimeError("Failed to open sys.yall"))
-	}
-	defer f.Close()
-	env.Load(f)
-	return env
+	return NewSandboxEnv(SandboxOptions{Builtins: AllBuiltins})
 }
 
 func (env *Env) Derive() *Env {
 	derived := new(Env)
 	derived.values = make(map[string]Expr)
-	derived.parent = env
+	derived.parent = env.lexical()
+	derived.table = env.table
+	derived.dynamic = env.dynamic
 	return derived
 }
 
+func (env *Env) root() *Env {
+	for env.parent != nil {
+		env = env.parent
+	}
+	return env
+}
+
+// SetInput sets the current input port of env to read from input.
+func (env *Env) SetInput(input io.Reader) {
+	port := NewInputPort(input)
+	port.r.table = env.table
+	env.setCurrentInput(port)
+}
+
+// SetOutput sets the current output port of env to write to output.
+func (env *Env) SetOutput(output io.Writer) {
+	env.setCurrentOutput(NewOutputPort(output))
+}
+
+func (env *Env) currentInput() *InputPort {
+	root := env.root()
+	root.lock.RLock()
+	defer root.lock.RUnlock()
+	return root.input
+}
+
+func (env *Env) setCurrentInput(port *InputPort) {
+	root := env.root()
+	root.lock.Lock()
+	defer root.lock.Unlock()
+	root.input = port
+}
+
+func (env *Env) currentOutput() *OutputPort {
+	if env.dynamic != nil && env.dynamic.output != nil {
+		return env.dynamic.output
+	}
+	root := env.root()
+	root.lock.RLock()
+	defer root.lock.RUnlock()
+	return root.output
+}
+
+func (env *Env) setCurrentOutput(port *OutputPort) {
+	root := env.root()
+	root.lock.Lock()
+	defer root.lock.Unlock()
+	root.output = port
+}
+
 func (env *Env) internSpecialForm(s string, f func(*Env, *Cell) Expr) {
 	env.internVariable(s, NewSpecialForm(s, f))
 }
 
 func (env *Env) internFunction(s string, f func(*Cell) Expr) {
-	env.internVariable(s, NewFunction(s, f))
+	function := NewFunction(s, f)
+	function.key = s
+	env.internVariable(s, function)
+}
+
+// internEnvFunction interns a function that is given env as well as its
+// arguments.
+func (env *Env) internEnvFunction(s string, f func(*Env, *Cell) Expr) {
+	function := newClosureFunction(s, &closure{env: env, builtin: f})
+	function.key = s
+	env.internVariable(s, function)
 }
 
 func (env *Env) internVariable(s string, value Expr) {
-	if nil != env.values[s] {
+	env = env.lexical()
+	env.lock.Lock()
+	defer env.lock.Unlock()
+	old, found := env.values[s]
+	if nil != old {
 		panic(NewRuntimeError("Can't overwrite " + s))
 	}
+	if !found && env.origin != nil {
+		if _, inherited := env.origin.lookup(s); inherited {
+			panic(NewRuntimeError("Can't overwrite " + s))
+		}
+	}
 	env.values[s] = value
 }
 
@@ -62,28 +157,86 @@ func (env *Env) Intern(symbol *Symbol, value Expr) {
 }
 
 func (env *Env) Unintern(symbol *Symbol) {
+	env = env.lexical()
+	env.lock.Lock()
+	defer env.lock.Unlock()
+	if env.origin != nil {
+		env.values[symbol.Name()] = nil
+		return
+	}
 	delete(env.values, symbol.Name())
 }
 
-func (env *Env) EvalSymbol(symbol *Symbol) Expr {
-	if value, found := env.values[symbol.Name()]; found {
-		return value
+func (env *Env) lookup(name string) (Expr, bool) {
+	env.lock.RLock()
+	value, found := env.values[name]
+	env.lock.RUnlock()
+	if found {
+		return value, value != nil || env.origin == nil
 	}
-	if nil != env.parent {
-		if value := env.parent.EvalSymbol(symbol); value != nil {
+	if env.origin == nil {
+		return nil, false
+	}
+	return env.copyFromOrigin(name)
+}
+
+// frameOf returns the frame in which symbol is bound.
+func (env *Env) frameOf(symbol *Symbol) *Env {
+	for e := env; e != nil; e = e.parent {
+		if _, found := e.lookup(symbol.Name()); found {
+			return e
+		}
+	}
+	panic(NewRuntimeError("Unbound variable: " + symbol.String()))
+}
+
+func (env *Env) EvalSymbol(symbol *Symbol) Expr {
+	for e := env; e != nil; e = e.parent {
+		if value, found := e.lookup(symbol.Name()); found {
 			return value
 		}
 	}
+	if value, found := env.lookupQualified(symbol.Name()); found {
+		return value
+	}
 	panic(NewRuntimeError("Unbound variable: " + symbol.String()))
 }
 
+// Update replaces the value bound to symbol with the result of f applied
+// to it, and returns the new value.  No other goroutine can change the
+// binding in the meantime, so f must not use the frame that binds symbol.
+func (env *Env) Update(symbol *Symbol, f func(Expr) Expr) Expr {
+	frame := env.frameOf(symbol)
+	frame.lock.Lock()
+	defer frame.lock.Unlock()
+	old, found := frame.values[symbol.Name()]
+	if !found {
+		panic(NewRuntimeError("Unbound variable: " + symbol.String()))
+	}
+	value := f(old)
+	frame.values[symbol.Name()] = value
+	return value
+}
+
+// CompareAndSwap binds symbol to value if it is still bound to old, and
+// reports whether it did.
+func (env *Env) CompareAndSwap(symbol *Symbol, old Expr, value Expr) bool {
+	frame := env.frameOf(symbol)
+	frame.lock.Lock()
+	defer frame.lock.Unlock()
+	if current, found := frame.values[symbol.Name()]; !found || current != old {
+		return false
+	}
+	frame.values[symbol.Name()] = value
+	return true
+}
+
 func (env *Env) EvalEach(cell *Cell) *Cell {
 	if Empty == cell {
 		return Empty
 	}
 	return NewCell(env.Eval(cell.Car()),
 		env.EvalEach(cell.Cdr()))
-	return Empty
 }
 
 func (env *Env) EvalCell(cell *Cell) Expr {
@@ -91,12 +244,9 @@ func (env *Env) EvalCell(cell *Cell) Expr {
 	if form, ok := head.(*SpecialForm); ok {
 		return form.Apply(env, cell.Cdr())
 	} else if function, ok := head.(*Function); ok {
-		return function.Apply(env.EvalEach(cell.Cdr()))
+		return function.call(env, env.EvalEach(cell.Cdr()))
 	} else if macro, ok := head.(*Macro); ok {
-		return env.Eval(macro.Expand(cell.Cdr()))
-	}
-	for k, v := range env.values {
-		println("# " + k + ": " + v.String())
+		return env.Eval(macro.expand(env, cell.Cdr()))
 	}
 	panic(NewRuntimeError("Failed to eval cell: " + cell.String()))
 }
@@ -120,6 +270,11 @@ func (env *Env) EvalQuasiquoted(expr Expr) Expr {
 }
 
 func (env *Env) Eval(expr Expr) Expr {
+	if b := env.currentBudget(); b != nil {
+		b.spend()
+	} else if limited := env.limitMemory(); limited != nil {
+		return limited.Eval(expr)
+	}
 	if IsLiteral(expr) {
 		return expr
 	}
@@ -130,32 +285,57 @@ func (env *Env) Eval(expr Expr) Expr {
 		return quoted.expr
 	}
 	if quasiquoted, ok := expr.(*Quasiquoted); ok {
-		return env.EvalQuasiquoted(quasiquoted.expr)
+		return env.account(env.EvalQuasiquoted(quasiquoted.expr))
 	}
 	if cell, ok := expr.(*Cell); ok {
-		return env.EvalCell(cell)
+		return env.account(env.EvalCell(cell))
 	}
 	panic(NewRuntimeError("Failed to eval"))
 }
 
-func (env *Env) EvalString(s string) Expr {
-	if expr, _, err := ReadFromString(s); err == nil {
-		return env.Eval(expr)
+// NewReader returns a Reader that uses the reader macros defined in env.
+func (env *Env) NewReader(input io.Reader) *Reader {
+	return &Reader{&reader{input: bufio.NewReader(input), table: env.table, env: env}}
+}
+
+// EvalReader evaluates every expression read from r and returns the value
+// of the last one, or nil if there was none.
+func (env *Env) EvalReader(r *Reader) Expr {
+	var result Expr
+	for {
+		expr, err := r.Next()
+		if err == io.EOF {
+			return result
+		}
+		if err != nil {
+			panic(err)
+		}
+		result = env.Eval(expr)
 	}
-	return nil
 }
 
-func (env *Env) Load(file *os.File) {
-	r := &reader{bufio.NewReader(file)}
+// EvalString evaluates every form in s and returns the value of the last
+// one.  If s has a syntax error, the forms before it are evaluated and nil
+// is returned.
+func (env *Env) EvalString(s string) Expr {
+	r := env.NewReader(strings.NewReader(s))
+	var result Expr
 	for {
-		expr, _, err := r.Read()
+		expr, err := r.Next()
+		if err == io.EOF {
+			return result
+		}
 		if err != nil {
-			break
+			return nil
 		}
-		env.Eval(expr)
+		result = env.Eval(expr)
 	}
 }
 
+func (env *Env) Load(input io.Reader) {
+	env.EvalReader(env.NewReader(input))
+}
+
 func (env *Env) Begin(cell *Cell) Expr {
 	var result Expr
 	for Empty != cell {
@@ -172,6 +352,9 @@ func IsLiteral(expr Expr) bool {
 	if _, ok := expr.(*Integer); ok {
 		return true
 	}
+	if _, ok := expr.(*Float); ok {
+		return true
+	}
 	if _, ok := expr.(*String); ok {
 		return true
 	}
@@ -188,6 +371,9 @@ func typeOf(expr Expr) *Type {
 	if _, ok := expr.(*Integer); ok {
 		return TYPE_INTEGER
 	}
+	if _, ok := expr.(*Float); ok {
+		return TYPE_FLOAT
+	}
 	if _, ok := expr.(*String); ok {
 		return TYPE_STRING
 	}
@@ -206,5 +392,23 @@ func typeOf(expr Expr) *Type {
 	if _, ok := expr.(*Type); ok {
 		return TYPE_TYPE
 	}
+	if _, ok := expr.(*InputPort); ok {
+		return TYPE_INPUT_PORT
+	}
+	if _, ok := expr.(*OutputPort); ok {
+		return TYPE_OUTPUT_PORT
+	}
+	if _, ok := expr.(*Channel); ok {
+		return TYPE_CHANNEL
+	}
+	if g, ok := expr.(*GoValue); ok {
+		return NewType(g.typeName())
+	}
+	if _, ok := expr.(*RuntimeError); ok {
+		return TYPE_ERROR
+	}
+	if _, ok := expr.(*SyntaxError); ok {
+		return TYPE_ERROR
+	}
 	return TYPE_UNKNOWN
 }
@@ -5,7 +5,9 @@
 package yall
 
 import (
+	"bytes"
 	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -20,6 +22,9 @@ var evalTestCases = []evalTestCase{
 	evalTestCase{"(+ 1 2)", "3"},
 	evalTestCase{"(+ 1 (* 2 3))", "7"},
 	evalTestCase{"(cons 1 '(2))", "(1 2)"},
+	evalTestCase{"(def a 1) (def b 2) (+ a b)", "3"},
+	evalTestCase{"(defn (f x) (* x x))\n(f 4)", "16"},
+	evalTestCase{"; comment\n(+ 1 ; one\n 2) ; end", "3"},
 }
 
 func TestEval(t *testing.T) {
@@ -70,3 +75,86 @@ func TestEval4(t *testing.T) {
 		t.Errorf("Something is wrong with closure.")
 	}
 }
+
+// Syntax errors in EvalString
+func TestEvalStringSyntaxError(t *testing.T) {
+	env := NewEnv()
+	if expr := env.EvalString("(def x 1) (+ x"); expr != nil {
+		t.Errorf("Received [[%v]] when expecting nil", expr)
+	}
+	if expr := env.EvalString("x"); expr.String() != "1" {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", expr, "1")
+	}
+}
+
+// Reader macros defined in yall
+func TestSetMacroCharacter(t *testing.T) {
+	env := NewEnv()
+	env.EvalString("(set-macro-character \"#r\" (fn (port key) (read port)))")
+	env.EvalString("(set-macro-character \"#sum\" (fn (port key) (list '+ (read port) (read port))))")
+	if expr := env.EvalString("#r\"a+b\""); expr.String() != "\"a+b\"" {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", expr, "\"a+b\"")
+	}
+	if expr := env.EvalString("(* 2 #sum 1 2)"); expr.String() != "6" {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", expr, "6")
+	}
+}
+
+func TestWriteDisplay(t *testing.T) {
+	env := NewEnv()
+	buffer := new(bytes.Buffer)
+	env.internVariable("out", NewOutputPort(buffer))
+	env.EvalString("(write '(\"a\\\"b\" c) out) (newline out) (display '(\"a\\\"b\" c) out)")
+	answer := "(\"a\\\"b\" c)\n(a\"b c)"
+	if buffer.String() != answer {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", buffer.String(), answer)
+	}
+}
+
+func TestPorts(t *testing.T) {
+	env := NewEnv()
+	buffer := new(bytes.Buffer)
+	env.SetOutput(buffer)
+	env.SetInput(strings.NewReader("first line\n(a b) c"))
+	env.EvalString("(println \"hello\") (display (read-line)) (write (read))")
+	env.EvalString("(display (read-char)) (write (read-char)) (write (read))")
+	answer := "hello\nfirst line(a b) \"c\"#<eof>"
+	if buffer.String() != answer {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", buffer.String(), answer)
+	}
+	if expr := env.EvalString("(with-output-to-string (fn () (display 1) (write \"a\")))"); expr.String() != "\"1\\\"a\\\"\"" {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", expr, "\"1\\\"a\\\"\"")
+	}
+	if expr := env.EvalString("(def p (open-input-string \"x (y)\")) (read p) (read p)"); expr.String() != "(y)" {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", expr, "(y)")
+	}
+	if expr := env.EvalString("(eof-object? (read p))"); expr != True {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", expr, True)
+	}
+}
+
+func TestFloat(t *testing.T) {
+	env := NewEnv()
+	for _, tc := range []evalTestCase{
+		evalTestCase{"1.5", "1.5"},
+		evalTestCase{"(+ 1 0.5)", "1.5"},
+		evalTestCase{"(* 2 2.0)", "4.0"},
+		evalTestCase{"(- 1.5)", "-1.5"},
+		evalTestCase{"(/ 1 4.0)", "0.25"},
+		evalTestCase{"(/ 7 2)", "3"},
+		evalTestCase{"(= 2 2.0)", "#t"},
+		evalTestCase{"(= 9007199254740993 9007199254740992)", "#f"},
+		evalTestCase{"(= 9007199254740993 9007199254740993)", "#t"},
+		evalTestCase{"(type-of 1e3)", "<float>"},
+		evalTestCase{"1e400", "+inf.0"},
+		evalTestCase{"(- +inf.0)", "-inf.0"},
+		evalTestCase{"(- +inf.0 +inf.0)", "+nan.0"},
+		evalTestCase{"(type-of (read (open-input-string (with-output-to-string (fn () (write (- +inf.0 +inf.0)))))))", "<float>"},
+		evalTestCase{"(try (/ 1 0) error-message)", "\"Division by zero\""},
+		evalTestCase{"(try (+ 1 \"a\") error-message)", "\"+ requires numbers, but got \\\"a\\\"\""},
+	} {
+		if expr := env.EvalString(tc.input); expr.String() != tc.output {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+		}
+	}
+}
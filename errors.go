@@ -4,7 +4,12 @@
 
 package yall
 
-import "strings"
+import (
+	"errors"
+	"math"
+	"regexp"
+	"strings"
+)
 
 type SyntaxError struct {
 	message string
@@ -22,10 +27,31 @@ func (serr *SyntaxError) Error() string {
 	return serr.message
 }
 
+// ErrIncomplete is returned by the reader when the input ends in the middle
+// of an expression, such as inside an unclosed list or string.  More input
+// may complete it.
+var ErrIncomplete = errors.New("Incomplete input")
+
 func isString(s string) bool {
 	return strings.HasPrefix(s, "\"") && strings.HasSuffix(s, "\"")
 }
 
+var floatPattern = regexp.MustCompile(`^[-+]?([0-9]+\.[0-9]*|\.[0-9]+|[0-9]+)([eE][-+]?[0-9]+)?$`)
+
+// floatNames are the floats written without digits.
+var floatNames = map[string]float64{
+	"+nan.0": math.NaN(),
+	"+inf.0": math.Inf(1),
+	"-inf.0": math.Inf(-1),
+}
+
+// isFloat reports whether s is a float literal.  It matches integer literals
+// too, but the reader reads those as Integers, or reports an error if they
+// are too large for one, before trying isFloat.
+func isFloat(s string) bool {
+	return floatPattern.MatchString(s)
+}
+
 type RuntimeError struct {
 	message string
 }
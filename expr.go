@@ -5,8 +5,11 @@
 package yall
 
 import (
+	"bytes"
 	"fmt"
+	"math"
 	"strconv"
+	"strings"
 )
 
 type Expr interface {
@@ -16,12 +19,14 @@ type Expr interface {
 type Cell struct {
 	car Expr
 	cdr *Cell
+	// accounted is set once an evaluation has been charged for the cell.
+	accounted uint32
 }
 
-var Empty *Cell = &Cell{nil, nil}
+var Empty *Cell = &Cell{nil, nil, 1}
 
 func NewCell(car Expr, cdr *Cell) *Cell {
-	return &Cell{car, cdr}
+	return &Cell{car: car, cdr: cdr}
 }
 
 func (cell *Cell) stringWithoutParens() string {
@@ -103,12 +108,43 @@ func (integer *Integer) Value() int {
 	return integer.value
 }
 
-func (integer *Integer) setValue(value int) {
-	integer.value = value
+type Float struct {
+	value float64
+}
+
+func NewFloat(value float64) *Float {
+	return &Float{value}
+}
+
+// String returns the shortest representation of f that reads back as f.
+// It always has a decimal point or an exponent, so that it reads back as a
+// float rather than an integer.
+// String prints NaN and the infinities as +nan.0, +inf.0 and -inf.0, which
+// the reader reads back as floats.
+func (f *Float) String() string {
+	switch {
+	case math.IsNaN(f.value):
+		return "+nan.0"
+	case math.IsInf(f.value, 1):
+		return "+inf.0"
+	case math.IsInf(f.value, -1):
+		return "-inf.0"
+	}
+	s := strconv.FormatFloat(f.value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eInN") {
+		s += ".0"
+	}
+	return s
+}
+
+func (f *Float) Value() float64 {
+	return f.value
 }
 
 type String struct {
 	value string
+	// accounted is set once an evaluation has been charged for the string.
+	accounted uint32
 }
 
 func NewString(value string) *String {
@@ -117,8 +153,53 @@ func NewString(value string) *String {
 	return s
 }
 
+// String returns s as a string literal, which reads back as s.
 func (s *String) String() string {
-	return "\"" + s.value + "\""
+	buffer := bytes.NewBufferString("\"")
+	for _, rune := range s.value {
+		switch rune {
+		case '"', '\\':
+			buffer.WriteRune('\\')
+			buffer.WriteRune(rune)
+		case '\n':
+			buffer.WriteString("\\n")
+		case '\t':
+			buffer.WriteString("\\t")
+		case '\r':
+			buffer.WriteString("\\r")
+		default:
+			buffer.WriteRune(rune)
+		}
+	}
+	buffer.WriteRune('"')
+	return buffer.String()
+}
+
+func (s *String) Value() string {
+	return s.value
+}
+
+// Display returns the human readable representation of expr, in which
+// strings are printed without quotes and escapes.
+func Display(expr Expr) string {
+	switch e := expr.(type) {
+	case *String:
+		return e.value
+	case *Cell:
+		if e == Empty {
+			return "()"
+		}
+		buffer := bytes.NewBufferString("(")
+		for c := e; c != Empty; c = c.cdr {
+			if c != e {
+				buffer.WriteString(" ")
+			}
+			buffer.WriteString(Display(c.car))
+		}
+		buffer.WriteString(")")
+		return buffer.String()
+	}
+	return expr.String()
 }
 
 type Quoted struct {
@@ -177,9 +258,30 @@ func (unquoted *SplicingUnquoted) String() string {
 	return ",@" + unquoted.expr.String()
 }
 
+// Comment is a comment in the source.  The reader returns comments only
+// when it is asked to keep them, as the formatter does.
+type Comment struct {
+	text     string
+	trailing bool
+}
+
+func NewComment(text string, trailing bool) *Comment {
+	return &Comment{text, trailing}
+}
+
+func (comment *Comment) String() string {
+	return comment.text
+}
+
 type Function struct {
 	name string
 	f    func(*Cell) Expr
+	// closure is what f was made from, if it was made from a lambda or
+	// captures an Env.
+	closure *closure
+	// key is the name a builtin was installed as, by which it is found
+	// again when an image is loaded.
+	key string
 }
 
 func NewFunction(name string, f func(*Cell) Expr) *Function {
@@ -202,8 +304,9 @@ func (function *Function) Apply(args *Cell) Expr {
 }
 
 type Macro struct {
-	name string
-	f    func(*Cell) Expr
+	name    string
+	f       func(*Cell) Expr
+	closure *closure
 }
 
 func NewMacro(name string, f func(*Cell) Expr) *Macro {
@@ -274,10 +377,15 @@ func (t *Type) String() string {
 var TYPE_CELL *Type = NewType("cell")
 var TYPE_SYMBOL *Type = NewType("symbol")
 var TYPE_INTEGER *Type = NewType("integer")
+var TYPE_FLOAT *Type = NewType("float")
 var TYPE_STRING *Type = NewType("string")
 var TYPE_FUNCTION *Type = NewType("function")
 var TYPE_MACRO *Type = NewType("macro")
 var TYPE_SPECIAL_FORM *Type = NewType("special-form")
 var TYPE_BOOL *Type = NewType("bool")
 var TYPE_TYPE *Type = NewType("type")
+var TYPE_INPUT_PORT *Type = NewType("input-port")
+var TYPE_OUTPUT_PORT *Type = NewType("output-port")
+var TYPE_ERROR *Type = NewType("error")
+var TYPE_CHANNEL *Type = NewType("channel")
 var TYPE_UNKNOWN *Type = NewType("unknown")
@@ -0,0 +1,369 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Version is the version of yall.  Compiled files written by another
+// version are not used.
+const Version = "0.1"
+
+// A compiled file, or fasl, holds the top-level forms of a source file
+// after reading, with those that are macro calls expanded.  It starts with
+// (yall-fasl "version" "hash"), where hash is the SHA-256 of the source,
+// followed by an entry for each form:
+//
+//	(expanded)
+//	(expanded original ("macro" "fingerprint")...)
+//
+// The second kind is for forms that were expanded, and records the macros
+// that expanded them.  Such a form is expanded again when any of them has
+// changed by the time it is evaluated.  Only the expansions by pure macros
+// are kept, so that they cannot depend on anything else.
+//
+// Whether a compiled form is fresh depends on the Env loading it, as the
+// macros are looked up there.  The builtins are taken to be the same in
+// every Env they are installed in, including Go functions registered under
+// the same name.
+
+// EnableFaslCache makes load and require in env keep compiled files of
+// the files they load from the host's file system, and use them instead
+// of reading and expanding the source again while it is unchanged.  The
+// compiled file of name.yall is name.fasl next to it if dir is empty, and
+// is kept in dir otherwise.  Compiled files that cannot be written are
+// silently skipped.
+func (env *Env) EnableFaslCache(dir string) {
+	root := env.root()
+	root.lock.Lock()
+	defer root.lock.Unlock()
+	root.fasl = true
+	root.faslDir = dir
+}
+
+// faslPath returns the compiled file of path, if env keeps them.
+func (env *Env) faslPath(path string) (string, bool) {
+	root := env.root()
+	root.lock.RLock()
+	defer root.lock.RUnlock()
+	if !root.fasl || root.fsys != nil {
+		return "", false
+	}
+	stem := strings.TrimSuffix(path, filepath.Ext(path))
+	if root.faslDir == "" {
+		return stem + ".fasl", true
+	}
+	sum := sha256.Sum256([]byte(path))
+	name := hex.EncodeToString(sum[:8]) + "-" + filepath.Base(stem) + ".fasl"
+	return filepath.Join(root.faslDir, name), true
+}
+
+type faslEntry struct {
+	expanded Expr
+	original Expr
+	// macros maps the names of the macros that expanded original to
+	// their fingerprints.
+	macros map[string]string
+}
+
+// macroFingerprint identifies the definition of macro, so that a form can
+// be expanded again when a macro it used is redefined.
+func macroFingerprint(macro *Macro) string {
+	s := macro.name
+	if c := macro.closure; c != nil && c.builtin == nil {
+		s += " " + c.lambdaList.String() + " " + c.body.String()
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// valueOf returns the value name is bound to in env, if any.
+func (env *Env) valueOf(name string) (Expr, bool) {
+	for e := env; e != nil; e = e.parent {
+		if value, found := e.lookup(name); found {
+			return value, true
+		}
+	}
+	return env.lookupQualified(name)
+}
+
+// macroNamed returns the macro bound to name in env, if any.
+func (env *Env) macroNamed(name string) (*Macro, bool) {
+	value, _ := env.valueOf(name)
+	macro, ok := value.(*Macro)
+	return macro, ok
+}
+
+// compileForm expands expr if it is a call to pure macros, as EvalCell
+// would when evaluating it.  Only the form itself is expanded, not the
+// macro calls in it, since whether those are calls to the same macros when
+// evaluated can depend on what is defined before.
+func (env *Env) compileForm(expr Expr) *faslEntry {
+	entry := &faslEntry{expanded: expr, original: expr}
+	macros := make(map[string]string)
+	for {
+		cell, ok := entry.expanded.(*Cell)
+		if !ok || cell == Empty {
+			break
+		}
+		symbol, ok := cell.car.(*Symbol)
+		if !ok {
+			break
+		}
+		macro, ok := env.macroNamed(symbol.name)
+		if !ok {
+			break
+		}
+		if !env.isPure(macro) {
+			return &faslEntry{expanded: expr, original: expr}
+		}
+		macros[symbol.name] = macroFingerprint(macro)
+		entry.expanded = macro.expand(env, cell.cdr)
+	}
+	if 0 < len(macros) {
+		entry.macros = macros
+	}
+	return entry
+}
+
+// isPure reports whether the expansions by macro depend only on its
+// arguments: it must be defined in the global environment of env, and
+// refer to nothing but its parameters and the builtins.  isFresh could not
+// tell whether anything else it used has changed.
+func (env *Env) isPure(macro *Macro) bool {
+	c := macro.closure
+	if c == nil || c.builtin != nil {
+		return true
+	}
+	root := env.root()
+	if c.env != root {
+		return false
+	}
+	bound := make(map[string]bool)
+	addBound(bound, c.lambdaList)
+	pure := true
+	eachReference(c.body, func(symbol *Symbol) {
+		if bound[symbol.name] {
+			return
+		}
+		if value, found := root.lookup(symbol.name); !found || !isInstalled(symbol.name, value) {
+			pure = false
+		}
+	})
+	return pure
+}
+
+// addBound adds the names in lambdaList to bound.
+func addBound(bound map[string]bool, lambdaList *Cell) {
+	for c := lambdaList; c != Empty; c = c.cdr {
+		switch e := c.car.(type) {
+		case *Symbol:
+			bound[e.name] = true
+		case *Cell:
+			addBound(bound, e)
+		}
+	}
+}
+
+// eachReference calls f with each symbol in expr that may refer to a
+// binding, leaving out quoted data.
+func eachReference(expr Expr, f func(*Symbol)) {
+	switch e := expr.(type) {
+	case *Symbol:
+		f(e)
+	case *Cell:
+		for c := e; c != Empty; c = c.cdr {
+			eachReference(c.car, f)
+		}
+	case *Quasiquoted:
+		eachUnquoted(e.expr, f)
+	}
+}
+
+func eachUnquoted(expr Expr, f func(*Symbol)) {
+	switch e := expr.(type) {
+	case *Unquoted:
+		eachReference(e.expr, f)
+	case *SplicingUnquoted:
+		eachReference(e.expr, f)
+	case *Cell:
+		for c := e; c != Empty; c = c.cdr {
+			eachUnquoted(c.car, f)
+		}
+	}
+}
+
+// isFresh reports whether the macros that expanded entry are still the
+// same, and pure, in env.
+func (env *Env) isFresh(entry *faslEntry) bool {
+	for name, fingerprint := range entry.macros {
+		macro, ok := env.macroNamed(name)
+		if !ok || macroFingerprint(macro) != fingerprint || !env.isPure(macro) {
+			return false
+		}
+	}
+	return true
+}
+
+func (entry *faslEntry) form() Expr {
+	if entry.macros == nil {
+		return listOf([]Expr{entry.expanded})
+	}
+	names := make([]string, 0, len(entry.macros))
+	for name := range entry.macros {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	exprs := []Expr{entry.expanded, entry.original}
+	for _, name := range names {
+		exprs = append(exprs, listOf([]Expr{NewString(name), NewString(entry.macros[name])}))
+	}
+	return listOf(exprs)
+}
+
+// readFaslEntry returns the entry written as form, or false if form is not
+// one.
+func readFaslEntry(form Expr) (*faslEntry, bool) {
+	cell, ok := form.(*Cell)
+	if !ok || cell == Empty {
+		return nil, false
+	}
+	entry := &faslEntry{expanded: cell.car, original: cell.car}
+	if cell.cdr == Empty {
+		return entry, true
+	}
+	// The original of an expanded form is a call to the first of the
+	// macros that follow it.
+	original, ok := cell.Cadr().(*Cell)
+	if !ok || original == Empty || cell.cdr.cdr == Empty {
+		return nil, false
+	}
+	head, ok := original.car.(*Symbol)
+	if !ok {
+		return nil, false
+	}
+	entry.original = original
+	entry.macros = make(map[string]string)
+	for c := cell.cdr.cdr; c != Empty; c = c.cdr {
+		pair, ok := c.car.(*Cell)
+		if !ok || pair == Empty || pair.cdr == Empty || pair.cdr.cdr != Empty {
+			return nil, false
+		}
+		name, ok := pair.car.(*String)
+		fingerprint, fok := pair.Cadr().(*String)
+		if !ok || !fok {
+			return nil, false
+		}
+		entry.macros[name.value] = fingerprint.value
+	}
+	if _, ok := entry.macros[head.name]; !ok {
+		return nil, false
+	}
+	return entry, true
+}
+
+// readFasl returns the entries of the compiled file path of the source
+// with hash, or false if it is missing, stale or malformed.
+func readFasl(path string, hash string) ([]*faslEntry, bool) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	forms, err := NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil || len(forms) == 0 {
+		return nil, false
+	}
+	if forms[0].String() != listOf([]Expr{NewSymbol("yall-fasl"), NewString(Version), NewString(hash)}).String() {
+		return nil, false
+	}
+	entries := make([]*faslEntry, 0, len(forms)-1)
+	for _, form := range forms[1:] {
+		entry, ok := readFaslEntry(form)
+		if !ok {
+			return nil, false
+		}
+		entries = append(entries, entry)
+	}
+	return entries, true
+}
+
+// writeFasl writes entries to path, unless some form would not read back
+// as itself.  It is written to a temporary file first, so that a partly
+// written file is never used.
+func writeFasl(path string, hash string, entries []*faslEntry) {
+	for _, entry := range entries {
+		if !isData(entry.expanded) || !isData(entry.original) {
+			return
+		}
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+	file, err := os.CreateTemp(dir, filepath.Base(path)+".*")
+	if err != nil {
+		return
+	}
+	defer os.Remove(file.Name())
+	var buffer bytes.Buffer
+	buffer.WriteString(listOf([]Expr{NewSymbol("yall-fasl"), NewString(Version), NewString(hash)}).String() + "\n")
+	for _, entry := range entries {
+		buffer.WriteString(entry.form().String() + "\n")
+	}
+	_, err = file.Write(buffer.Bytes())
+	if closeErr := file.Close(); err != nil || closeErr != nil {
+		return
+	}
+	os.Rename(file.Name(), path)
+}
+
+// loadCompiled evaluates the source read from file like Load, using the
+// compiled file faslPath if it is fresh and updating it otherwise.
+func (env *Env) loadCompiled(file io.Reader, faslPath string) error {
+	source, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(source)
+	hash := hex.EncodeToString(sum[:])
+	entries, fresh := readFasl(faslPath, hash)
+	if fresh {
+		for i, entry := range entries {
+			if !env.isFresh(entry) {
+				entries[i] = env.compileForm(entry.original)
+				fresh = false
+			}
+			env.Eval(entries[i].expanded)
+		}
+	} else {
+		// The source is read a form at a time, since evaluating a form
+		// can change how the next one is read.
+		r := env.NewReader(bytes.NewReader(source))
+		for {
+			expr, err := r.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				panic(err)
+			}
+			entry := env.compileForm(expr)
+			env.Eval(entry.expanded)
+			entries = append(entries, entry)
+		}
+	}
+	if !fresh {
+		writeFasl(faslPath, hash, entries)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func loadWithFasl(t *testing.T, source string, cacheDir string, setup string) *Env {
+	env := NewEnv()
+	env.EnableFaslCache(cacheDir)
+	env.EvalString(setup)
+	if err := env.LoadFile(source); err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func TestFasl(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "lib.yall")
+	fasl := filepath.Join(dir, "lib.fasl")
+	write := func(path string, content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(source, `
+(defmacro (double x) (list '* 2 x))
+(defmacro (define-double name x) (list 'def name (list 'double x)))
+(define-double answer 21)
+(defn (twice f x) (f (f x)))
+(outer greeting "hello")
+(defn (shadow double) (double 3))
+(def quoted '(double 1))
+(defn (later) (triple 2))
+(defmacro (triple x) (list '* 3 x))
+(defmacro (m x) "macro")
+(defn (local) (def m (fn (x) 'fn)) (m 1))
+(defmacro (define-helped name x) (list 'def name (helper x)))
+(define-helped helped 1)`)
+	setup := "(defmacro (outer name x) (list 'def name x)) (defn (helper x) (+ x 1))"
+	check := func(env *Env, tests []evalTestCase) {
+		for _, tc := range tests {
+			if expr := env.EvalString(tc.input); expr.String() != tc.output {
+				t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+			}
+		}
+	}
+	env := loadWithFasl(t, source, "", setup)
+	check(env, []evalTestCase{
+		evalTestCase{"answer", "42"},
+		evalTestCase{"(twice (fn (x) (+ x 1)) 0)", "2"},
+		evalTestCase{"greeting", "\"hello\""},
+		evalTestCase{"(shadow (fn (x) (+ x 1)))", "4"},
+		evalTestCase{"quoted", "(double 1)"},
+		evalTestCase{"(later)", "6"},
+		evalTestCase{"(local)", "fn"},
+		evalTestCase{"helped", "2"},
+	})
+	compiled, err := ioutil.ReadFile(fasl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(compiled), "(yall-fasl \""+Version+"\" \"") ||
+		!strings.Contains(string(compiled), "((def answer (double 21)) (define-double answer 21) (\"define-double\" ") ||
+		!strings.Contains(string(compiled), "\n((define-helped helped 1))\n") {
+		t.Errorf("Received [[%v]] as the compiled file", string(compiled))
+	}
+	// The compiled file is used while the source is unchanged.
+	write(fasl, strings.Replace(string(compiled), "(double 21)", "(double 50)", 1))
+	check(loadWithFasl(t, source, "", setup), []evalTestCase{
+		evalTestCase{"answer", "100"},
+		evalTestCase{"(shadow (fn (x) (+ x 1)))", "4"},
+		evalTestCase{"(later)", "6"},
+		evalTestCase{"(local)", "fn"},
+	})
+	// A form is expanded again when a macro it used has changed.  The
+	// expansions by macros calling other functions are not kept.
+	check(loadWithFasl(t, source, "", "(defmacro (outer name x) (list 'def name (list 'list x))) (defn (helper x) (+ x 100))"), []evalTestCase{
+		evalTestCase{"answer", "100"},
+		evalTestCase{"greeting", "(\"hello\")"},
+		evalTestCase{"helped", "101"},
+	})
+	check(loadWithFasl(t, source, "", setup), []evalTestCase{
+		evalTestCase{"greeting", "\"hello\""},
+	})
+	// The compiled file is written again when the source changes.
+	write(source, "(defmacro (define-double name x) (list 'def name (list '* 2 x))) (define-double answer 4)")
+	check(loadWithFasl(t, source, "", setup), []evalTestCase{
+		evalTestCase{"answer", "8"},
+	})
+	if compiled, err = ioutil.ReadFile(fasl); !strings.Contains(string(compiled), "(* 2 4)") {
+		t.Errorf("Received [[%v]] as the compiled file", string(compiled))
+	}
+	// Malformed compiled files are written again.
+	header := strings.SplitAfter(string(compiled), "\n")[0]
+	for _, entry := range []string{
+		"(x y (\"m\"))",
+		"((def answer 1) 5 (\"define-double\" \"f\"))",
+		"((def answer 1) (define-double answer 4))",
+		"((def answer 1) (define-double answer 4) (\"other\" \"f\"))",
+		"((def answer 1) (define-double answer 4) (\"define-double\" \"f\" \"g\"))",
+		"((def answer 1) (define-double answer 4) (\"define-double\" f))",
+		"())",
+	} {
+		write(fasl, header+"((defmacro (define-double name x) (list 'def name (list '* 2 x))))\n"+entry+"\n")
+		check(loadWithFasl(t, source, "", setup), []evalTestCase{
+			evalTestCase{"answer", "8"},
+		})
+		if recompiled, _ := ioutil.ReadFile(fasl); string(recompiled) != string(compiled) {
+			t.Errorf("%v: Received [[%v]] as the compiled file", entry, string(recompiled))
+		}
+	}
+	cache := filepath.Join(dir, "cache")
+	check(loadWithFasl(t, source, cache, setup), []evalTestCase{
+		evalTestCase{"answer", "8"},
+	})
+	if files, _ := filepath.Glob(filepath.Join(cache, "*-lib.fasl")); len(files) != 1 {
+		t.Errorf("Received %v in the cache directory", files)
+	}
+	// Files are not compiled unless asked.
+	os.Remove(fasl)
+	env = NewEnv()
+	if err := env.LoadFile(source); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(fasl); !os.IsNotExist(err) {
+		t.Errorf("Received [[%v]] when expecting no compiled file", err)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+func fileError(name string, err error) *RuntimeError {
+	return NewRuntimeError(name + ": " + err.Error())
+}
+
+func openInputFile(env *Env, name string, path string) *InputPort {
+	file, err := os.Open(path)
+	if err != nil {
+		panic(fileError(name, err))
+	}
+	port := newInputPort(env.NewReader(file).r)
+	port.closer = file
+	return port
+}
+
+func openOutputFile(name string, path string, flag int) *OutputPort {
+	file, err := os.OpenFile(path, flag, 0666)
+	if err != nil {
+		panic(fileError(name, err))
+	}
+	port := NewOutputPort(file)
+	port.closer = file
+	return port
+}
+
+// withPort calls f with port and closes the port when f returns, even if
+// it panics.
+func withPort(port Expr, f func() Expr) Expr {
+	defer closePort(port)
+	return f()
+}
+
+func closePort(port Expr) {
+	var err error
+	switch p := port.(type) {
+	case *InputPort:
+		err = p.Close()
+	case *OutputPort:
+		err = p.Close()
+	default:
+		panic(NewRuntimeError("close-port requires a port, but got " + port.String()))
+	}
+	if err != nil {
+		panic(fileError("close-port", err))
+	}
+}
+
+// fileFunctions are the builtin functions that access the file system.
+var fileFunctions = map[string]func(*Env, *Cell) Expr{
+
+	"open-input-file": func(env *Env, args *Cell) Expr {
+		return openInputFile(env, "open-input-file", stringArg("open-input-file", args.Car()))
+	},
+
+	"open-output-file": func(env *Env, args *Cell) Expr {
+		path := stringArg("open-output-file", args.Car())
+		return openOutputFile("open-output-file", path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	},
+
+	"close-port": func(env *Env, args *Cell) Expr {
+		closePort(args.Car())
+		return True
+	},
+
+	"call-with-input-file": func(env *Env, args *Cell) Expr {
+		port := openInputFile(env, "call-with-input-file", stringArg("call-with-input-file", args.Car()))
+		return withPort(port, func() Expr {
+			return functionArg("call-with-input-file", args.Cadr()).call(env, NewCell(port, Empty))
+		})
+	},
+
+	"call-with-output-file": func(env *Env, args *Cell) Expr {
+		path := stringArg("call-with-output-file", args.Car())
+		port := openOutputFile("call-with-output-file", path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		return withPort(port, func() Expr {
+			return functionArg("call-with-output-file", args.Cadr()).call(env, NewCell(port, Empty))
+		})
+	},
+
+	"read-file-string": func(env *Env, args *Cell) Expr {
+		content, err := ioutil.ReadFile(stringArg("read-file-string", args.Car()))
+		if err != nil {
+			panic(fileError("read-file-string", err))
+		}
+		return NewString(string(content))
+	},
+
+	"write-file-string": func(env *Env, args *Cell) Expr {
+		path := stringArg("write-file-string", args.Car())
+		content := stringArg("write-file-string", args.Cadr())
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			panic(fileError("write-file-string", err))
+		}
+		return True
+	},
+
+	"file-exists?": func(env *Env, args *Cell) Expr {
+		if _, err := os.Stat(stringArg("file-exists?", args.Car())); err == nil {
+			return True
+		}
+		return False
+	},
+
+	"delete-file": func(env *Env, args *Cell) Expr {
+		if err := os.Remove(stringArg("delete-file", args.Car())); err != nil {
+			panic(fileError("delete-file", err))
+		}
+		return True
+	},
+
+	"directory-list": func(env *Env, args *Cell) Expr {
+		file, err := os.Open(stringArg("directory-list", args.Car()))
+		if err != nil {
+			panic(fileError("directory-list", err))
+		}
+		defer file.Close()
+		names, err := file.Readdirnames(-1)
+		if err != nil {
+			panic(fileError("directory-list", err))
+		}
+		sort.Strings(names)
+		list := Empty
+		for i := len(names) - 1; 0 <= i; i-- {
+			list = NewCell(NewString(names[i]), list)
+		}
+		return list
+	},
+}
+
+// fileSpecialForms are the special forms that access the file system.
+var fileSpecialForms = map[string]func(*Env, *Cell) Expr{
+
+	// (with-open-file (var path [mode]) body...) binds var to a port on
+	// the file and closes it when body returns.  mode is "r" to read,
+	// which is the default, "w" to write or "a" to append.
+	"with-open-file": func(env *Env, args *Cell) Expr {
+		spec, ok := args.Car().(*Cell)
+		if !ok || Empty == spec {
+			panic(NewRuntimeError("with-open-file requires (var path [mode])"))
+		}
+		symbol, ok := spec.Car().(*Symbol)
+		if !ok {
+			panic(NewRuntimeError("with-open-file requires a symbol to bind"))
+		}
+		path := stringArg("with-open-file", env.Eval(spec.Cadr()))
+		mode := "r"
+		if Empty != spec.Cdr().Cdr() {
+			mode = stringArg("with-open-file", env.Eval(spec.Caddr()))
+		}
+		var port Expr
+		switch mode {
+		case "r":
+			port = openInputFile(env, "with-open-file", path)
+		case "w":
+			port = openOutputFile("with-open-file", path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+		case "a":
+			port = openOutputFile("with-open-file", path, os.O_WRONLY|os.O_CREATE|os.O_APPEND)
+		default:
+			panic(NewRuntimeError("with-open-file: unknown mode " + mode))
+		}
+		return withPort(port, func() Expr {
+			derived := env.Derive()
+			derived.Intern(symbol, port)
+			return derived.Begin(args.Cdr())
+		})
+	},
+}
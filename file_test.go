@@ -0,0 +1,67 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileFunctions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	env := NewEnv()
+	env.Intern(NewSymbol("dir"), NewString(dir))
+	a := NewString(filepath.Join(dir, "a.txt"))
+	b := NewString(filepath.Join(dir, "b.yall"))
+	env.Intern(NewSymbol("a"), a)
+	env.Intern(NewSymbol("b"), b)
+	for _, tc := range []evalTestCase{
+		evalTestCase{"(file-exists? a)", "#f"},
+		evalTestCase{"(write-file-string a \"hello\")", "#t"},
+		evalTestCase{"(file-exists? a)", "#t"},
+		evalTestCase{"(read-file-string a)", "\"hello\""},
+		evalTestCase{"(call-with-output-file b (fn (port) (write '(+ 1 2) port) (newline port)))", "#t"},
+		evalTestCase{"(with-open-file (p b) (read p))", "(+ 1 2)"},
+		evalTestCase{"(with-open-file (p b \"a\") (display \"(* 3 4)\" p))", "#t"},
+		evalTestCase{"(call-with-input-file b (fn (port) (read port) (read port)))", "(* 3 4)"},
+		evalTestCase{"(load b)", "#t"},
+		evalTestCase{"(directory-list dir)", "(\"a.txt\" \"b.yall\")"},
+		evalTestCase{"(delete-file a)", "#t"},
+		evalTestCase{"(file-exists? a)", "#f"},
+		evalTestCase{"(try (read-file-string a) error-message)", "\"read-file-string: open " + a.value + ": no such file or directory\""},
+		evalTestCase{"(try (load a) (fn (e) 'failed))", "failed"},
+		evalTestCase{"(try (delete-file a) type-of)", "<error>"},
+	} {
+		if expr := env.EvalString(tc.input); expr.String() != tc.output {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+		}
+	}
+}
+
+func TestWithOpenFileCloses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	env := NewEnv()
+	env.Intern(NewSymbol("path"), NewString(filepath.Join(dir, "out.txt")))
+	var port Expr
+	env.internFunction("keep", func(args *Cell) Expr {
+		port = args.Car()
+		return port
+	})
+	env.EvalString("(try (with-open-file (p path \"w\") (keep p) (error \"boom\")) error-message)")
+	env.Intern(NewSymbol("port"), port)
+	if expr := env.EvalString("(try (display \"x\" port) error-message)"); expr.String() != "\"Failed to write: write to a closed port\"" {
+		t.Errorf("Received [[%v]], the port is not closed", expr)
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import "sync"
+
+// fork translates the frames and closures of the environment it was made
+// from to its own, so that closures defined before forking see the
+// bindings of the fork when called in it.
+type fork struct {
+	lock      sync.Mutex
+	base      *Env
+	frames    map[*Env]*Env
+	functions map[*Function]*Function
+	macros    map[*Macro]*Macro
+	cells     map[*Cell]*Cell
+}
+
+// frame returns the frame of the fork standing for frame of the base.
+func (f *fork) frame(frame *Env) *Env {
+	if copied, ok := f.frames[frame]; ok {
+		return copied
+	}
+	copied := new(Env)
+	copied.values = make(map[string]Expr)
+	copied.parent = f.frame(frame.parent)
+	copied.table = copied.parent.table
+	copied.origin = frame
+	copied.fork = f
+	f.frames[frame] = copied
+	return copied
+}
+
+// closure returns c made again over the frames of the fork, or nil if c
+// was not defined in the base.
+func (f *fork) closure(c *closure) *closure {
+	if c == nil || c.env.root() != f.base {
+		return nil
+	}
+	translated := *c
+	translated.env = f.frame(c.env)
+	return &translated
+}
+
+func (f *fork) translate(value Expr) Expr {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.value(value)
+}
+
+func (f *fork) value(value Expr) Expr {
+	switch v := value.(type) {
+	case *Cell:
+		return f.cell(v)
+	case *Function:
+		if translated, ok := f.functions[v]; ok {
+			return translated
+		}
+		if c := f.closure(v.closure); c != nil {
+			translated := newClosureFunction(v.name, c)
+			translated.key = v.key
+			f.functions[v] = translated
+			return translated
+		}
+	case *Macro:
+		if translated, ok := f.macros[v]; ok {
+			return translated
+		}
+		if c := f.closure(v.closure); c != nil {
+			f.macros[v] = newClosureMacro(v.name, c)
+			return f.macros[v]
+		}
+	}
+	return value
+}
+
+// cell returns cell with the closures in it translated.  Only the cells
+// leading to such closures are copied, so other data stays shared.  The
+// copies are kept, so that the same cell is copied once.
+func (f *fork) cell(cell *Cell) *Cell {
+	cells := []*Cell{}
+	tail := Empty
+	for c := cell; c != Empty; c = c.cdr {
+		if translated, ok := f.cells[c]; ok {
+			tail = translated
+			break
+		}
+		cells = append(cells, c)
+	}
+	for i := len(cells) - 1; 0 <= i; i-- {
+		c := cells[i]
+		if car := f.value(c.car); car != c.car || tail != c.cdr {
+			tail = NewCell(car, tail)
+			f.cells[c] = tail
+		} else {
+			tail = c
+		}
+	}
+	return tail
+}
+
+// copyFromOrigin copies the binding of name from the origin of env, which
+// is a frame of a fork.
+func (env *Env) copyFromOrigin(name string) (Expr, bool) {
+	value, found := env.origin.lookup(name)
+	if !found {
+		return nil, false
+	}
+	value = env.fork.translate(value)
+	env.lock.Lock()
+	defer env.lock.Unlock()
+	if current, ok := env.values[name]; ok {
+		return current, current != nil
+	}
+	env.values[name] = value
+	return value, true
+}
+
+// names returns the names bound in env, including those not copied from
+// its origin yet.
+func (env *Env) names() []string {
+	env.lock.RLock()
+	names := []string{}
+	seen := make(map[string]bool)
+	for name, value := range env.values {
+		seen[name] = true
+		if value != nil || env.origin == nil {
+			names = append(names, name)
+		}
+	}
+	env.lock.RUnlock()
+	if env.origin != nil {
+		for _, name := range env.origin.names() {
+			if !seen[name] {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// Fork returns a new global environment starting with the bindings of the
+// global environment of env.  Bindings are copied when the fork first uses
+// them, so forking is cheap, and nothing done in the fork affects env.
+// Closures defined in env run in the fork with the fork's bindings, also
+// when they are found in data such as lists.  Until the fork uses a
+// binding, it sees changes made to it in env; fork a Snapshot to avoid
+// that.  Other data is shared, as it is never modified.
+func (env *Env) Fork() *Env {
+	base := env.root()
+	forked := new(Env)
+	forked.values = make(map[string]Expr)
+	forked.table = base.table.copy()
+	forked.fsys = base.fsys
+	forked.memoryLimit = base.currentMemoryLimit()
+	forked.builtins = base.builtins
+	forked.origin = base
+	f := &fork{
+		base:      base,
+		frames:    map[*Env]*Env{base: forked},
+		functions: make(map[*Function]*Function),
+		macros:    make(map[*Macro]*Macro),
+		cells:     make(map[*Cell]*Cell),
+	}
+	forked.fork = f
+	base.lock.RLock()
+	forked.input = base.input
+	forked.output = base.output
+	forked.loadPaths = append([]string{}, base.loadPaths...)
+	forked.fasl, forked.faslDir = base.fasl, base.faslDir
+	forked.loaded = make(map[string]bool)
+	for path := range base.loaded {
+		forked.loaded[path] = true
+	}
+	modules := base.modules
+	base.lock.RUnlock()
+	forked.modules = make(map[string]*Module)
+	for name, module := range modules {
+		copied := newModule(name)
+		for export, value := range module.exports {
+			copied.exports[export] = f.translate(value)
+		}
+		forked.modules[name] = copied
+	}
+	return forked
+}
+
+// Snapshot returns a copy of the global environment of env that is not
+// affected by later changes to env.  Forks of the snapshot are cheap, and
+// all start from the same bindings as long as nothing is evaluated in the
+// snapshot itself.
+func (env *Env) Snapshot() *Env {
+	snapshot := env.Fork()
+	f := snapshot.fork
+	copied := make(map[*Env]bool)
+	for {
+		f.lock.Lock()
+		frames := []*Env{}
+		for _, frame := range f.frames {
+			if !copied[frame] {
+				frames = append(frames, frame)
+			}
+		}
+		f.lock.Unlock()
+		if len(frames) == 0 {
+			break
+		}
+		for _, frame := range frames {
+			for _, name := range frame.names() {
+				frame.lookup(name)
+			}
+			copied[frame] = true
+		}
+	}
+	for frame := range copied {
+		frame.origin = nil
+		frame.fork = nil
+		for name, value := range frame.values {
+			if value == nil {
+				delete(frame.values, name)
+			}
+		}
+	}
+	return snapshot
+}
@@ -0,0 +1,140 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"bytes"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestFork(t *testing.T) {
+	base := NewEnv()
+	baseOutput := new(bytes.Buffer)
+	base.SetOutput(baseOutput)
+	base.EvalString(`
+(def counter 0)
+(defn (bump) (inc! counter))
+(defn (greet) (display "hello"))
+(module tally (export next)
+  (def n 0)
+  (defn (next) (inc! n)))
+(set-macro-character "#one" (fn (port key) 1))`)
+	forked := base.Fork()
+	forkOutput := new(bytes.Buffer)
+	forked.SetOutput(forkOutput)
+	for _, tc := range []evalTestCase{
+		evalTestCase{"(bump)", "1"},
+		evalTestCase{"(bump)", "2"},
+		evalTestCase{"(tally/next)", "1"},
+		evalTestCase{"(def local 1)", "local"},
+		evalTestCase{"(set! counter (+ counter 10))", "12"},
+		evalTestCase{"(try (def bump 1) error-message)", "\"Can't overwrite bump\""},
+		evalTestCase{"(greet)", "#t"},
+		evalTestCase{"(set-macro-character \"#two\" (fn (port key) 2))", "#t"},
+		evalTestCase{"(+ #one #two)", "3"},
+		evalTestCase{"(map (fn (x) (* x 2)) '(1 2))", "(2 4)"},
+	} {
+		if expr := forked.EvalString(tc.input); expr.String() != tc.output {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+		}
+	}
+	for _, tc := range []evalTestCase{
+		evalTestCase{"counter", "0"},
+		evalTestCase{"(bump)", "1"},
+		evalTestCase{"(tally/next)", "1"},
+		evalTestCase{"(try local error-message)", "\"Unbound variable: local\""},
+		evalTestCase{"(try (read (open-input-string \"#two\")) error-message)", "#two"},
+	} {
+		if expr := base.EvalString(tc.input); expr.String() != tc.output {
+			t.Errorf("base: %v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+		}
+	}
+	if forkOutput.String() != "hello" || baseOutput.String() != "" {
+		t.Errorf("Received [[%v]] in the fork and [[%v]] in the base", forkOutput, baseOutput)
+	}
+	nested := forked.Fork()
+	if expr := nested.EvalString("(bump)"); expr.String() != "13" {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", expr, "13")
+	}
+	if expr := forked.EvalString("counter"); expr.String() != "12" {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", expr, "12")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	base := NewEnv()
+	base.EvalString("(def counter 0) (def other 0) (defn (bump) (inc! counter))")
+	snapshot := base.Snapshot()
+	live := base.Fork()
+	base.EvalString("(set! counter 100) (set! other 100)")
+	if expr := live.EvalString("other"); expr.String() != "100" {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", expr, "100")
+	}
+	var wg sync.WaitGroup
+	results := make([]string, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			env := snapshot.Fork()
+			for j := 0; j < 10; j++ {
+				env.EvalString("(bump)")
+			}
+			results[i] = env.EvalString("(list counter other)").String()
+		}(i)
+	}
+	wg.Wait()
+	for i, result := range results {
+		if result != "(10 0)" {
+			t.Errorf("fork %v: Received [[%v]] when expecting [[%v]]", strconv.Itoa(i), result, "(10 0)")
+		}
+	}
+	if expr := base.EvalString("counter"); expr.String() != "100" {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", expr, "100")
+	}
+}
+
+func TestForkData(t *testing.T) {
+	base := NewEnv()
+	base.EvalString("(def counter 0) (defn (bump) (inc! counter)) (def fs (list bump)) (def data '(1 (2 3)))")
+	for _, env := range []*Env{base.Fork(), base.Snapshot(), base.Snapshot().Fork()} {
+		for _, tc := range []evalTestCase{
+			evalTestCase{"((car fs))", "1"},
+			evalTestCase{"((car fs))", "2"},
+			evalTestCase{"counter", "2"},
+			evalTestCase{"data", "(1 (2 3))"},
+		} {
+			if expr := env.EvalString(tc.input); expr.String() != tc.output {
+				t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+			}
+		}
+		if env.EvalString("data") != base.EvalString("data") {
+			t.Errorf("The data was copied")
+		}
+	}
+	if expr := base.EvalString("counter"); expr.String() != "0" {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", expr, "0")
+	}
+	long := []Expr{base.EvalString("bump")}
+	for i := 0; i < 100000; i++ {
+		long = append(long, NewInteger(i))
+	}
+	base.Intern(NewSymbol("long"), listOf(long))
+	forked := base.Fork()
+	for _, tc := range []evalTestCase{
+		evalTestCase{"(car (cdr data))", "(2 3)"},
+		evalTestCase{"((car long))", "1"},
+		evalTestCase{"(car (cdr long))", "0"},
+	} {
+		if expr := forked.EvalString(tc.input); expr.String() != tc.output {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+		}
+	}
+	if n := len(forked.fork.cells); n != 1 {
+		t.Errorf("Received [[%v]] when expecting [[%v]] copied cells", n, 1)
+	}
+}
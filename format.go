@@ -0,0 +1,174 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// formatDirective is one ~ directive in a format control string, such as
+// ~10@a or ~5,'0x.
+type formatDirective struct {
+	params []string
+	at     bool
+	char   rune
+}
+
+// intParam returns the i-th parameter of d as an integer, or def if it is
+// missing.
+func (d *formatDirective) intParam(i int, def int) int {
+	if len(d.params) <= i || d.params[i] == "" {
+		return def
+	}
+	n, err := strconv.Atoi(d.params[i])
+	if err != nil {
+		panic(NewRuntimeError("format: invalid parameter " + d.params[i]))
+	}
+	return n
+}
+
+// maxCount is the largest count or column a directive may be given.
+const maxCount = 1 << 16
+
+// countParam returns the i-th parameter of d as a count of characters, or
+// def if it is missing.
+func (d *formatDirective) countParam(i int, def int) int {
+	n := d.intParam(i, def)
+	if n < 0 || maxCount < n {
+		panic(NewRuntimeError("format: parameter out of range: " + strconv.Itoa(n)))
+	}
+	return n
+}
+
+// charParam returns the i-th parameter of d, written as 'c, or def if it is
+// missing.
+func (d *formatDirective) charParam(i int, def rune) rune {
+	if len(d.params) <= i || d.params[i] == "" {
+		return def
+	}
+	if !strings.HasPrefix(d.params[i], "'") || utf8.RuneCountInString(d.params[i]) != 2 {
+		panic(NewRuntimeError("format: invalid character parameter " + d.params[i]))
+	}
+	r, _ := utf8.DecodeRuneInString(d.params[i][1:])
+	return r
+}
+
+func pad(s string, mincol int, padchar rune, left bool) string {
+	n := mincol - utf8.RuneCountInString(s)
+	if n <= 0 {
+		return s
+	}
+	padding := strings.Repeat(string(padchar), n)
+	if left {
+		return padding + s
+	}
+	return s + padding
+}
+
+// parseDirective parses the directive that follows a '~' at the start of
+// control, and returns it with the number of bytes it occupies.
+func parseDirective(control string) (*formatDirective, int) {
+	d := new(formatDirective)
+	param := new(bytes.Buffer)
+	for i := 0; i < len(control); {
+		r, size := utf8.DecodeRuneInString(control[i:])
+		i += size
+		switch {
+		case r == '\'' && param.Len() == 0 && i < len(control):
+			c, csize := utf8.DecodeRuneInString(control[i:])
+			i += csize
+			param.WriteRune(r)
+			param.WriteRune(c)
+		case ('0' <= r && r <= '9') || (r == '-' && param.Len() == 0):
+			param.WriteRune(r)
+		case r == ',':
+			d.params = append(d.params, param.String())
+			param.Reset()
+		case r == '@':
+			d.at = true
+		default:
+			if 0 < param.Len() || 0 < len(d.params) {
+				d.params = append(d.params, param.String())
+			}
+			d.char = r
+			return d, i
+		}
+	}
+	panic(NewRuntimeError("format: unterminated directive"))
+}
+
+func formatInteger(d *formatDirective, expr Expr, base int, params int) string {
+	integer, ok := expr.(*Integer)
+	if !ok {
+		return pad(Display(expr), d.countParam(params, 0), d.charParam(params+1, ' '), true)
+	}
+	s := strconv.FormatInt(int64(integer.Value()), base)
+	if d.at && 0 <= integer.Value() {
+		s = "+" + s
+	}
+	return pad(s, d.countParam(params, 0), d.charParam(params+1, ' '), true)
+}
+
+// formatString formats args according to control, which contains text and
+// Common Lisp style directives:
+//
+//	~a   the next argument as display prints it; ~mincol@a pads on the left
+//	~s   the next argument as write prints it
+//	~d   the next argument in decimal; ~mincol,'padchard pads it, ~@d adds +
+//	~b   binary, ~o octal, ~x hexadecimal, ~radix,mincol,'padcharr any radix
+//	~%   a newline
+//	~~   a tilde
+func formatString(control string, args *Cell) string {
+	buffer := new(bytes.Buffer)
+	original := control
+	next := func() Expr {
+		if Empty == args {
+			panic(NewRuntimeError("format: too few arguments for " + strconv.Quote(original)))
+		}
+		arg := args.Car()
+		args = args.Cdr()
+		return arg
+	}
+	for 0 < len(control) {
+		i := strings.IndexRune(control, '~')
+		if i < 0 {
+			buffer.WriteString(control)
+			break
+		}
+		buffer.WriteString(control[:i])
+		d, size := parseDirective(control[i+1:])
+		control = control[i+1+size:]
+		switch d.char {
+		case 'a', 'A':
+			buffer.WriteString(pad(Display(next()), d.countParam(0, 0), ' ', d.at))
+		case 's', 'S':
+			buffer.WriteString(pad(next().String(), d.countParam(0, 0), ' ', d.at))
+		case 'd', 'D':
+			buffer.WriteString(formatInteger(d, next(), 10, 0))
+		case 'b', 'B':
+			buffer.WriteString(formatInteger(d, next(), 2, 0))
+		case 'o', 'O':
+			buffer.WriteString(formatInteger(d, next(), 8, 0))
+		case 'x', 'X':
+			buffer.WriteString(formatInteger(d, next(), 16, 0))
+		case 'r', 'R':
+			radix := d.intParam(0, 10)
+			if radix < 2 || 36 < radix {
+				panic(NewRuntimeError("format: invalid radix " + strconv.Itoa(radix)))
+			}
+			buffer.WriteString(formatInteger(d, next(), radix, 1))
+		case '%':
+			buffer.WriteString(strings.Repeat("\n", d.countParam(0, 1)))
+		case '~':
+			buffer.WriteString(strings.Repeat("~", d.countParam(0, 1)))
+		default:
+			panic(NewRuntimeError("format: unknown directive ~" + string(d.char)))
+		}
+	}
+	return buffer.String()
+}
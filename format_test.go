@@ -0,0 +1,44 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"bytes"
+	"testing"
+)
+
+var formatStringTestCases = []evalTestCase{
+	evalTestCase{`(format #f "plain")`, `"plain"`},
+	evalTestCase{`(format () "~a and ~s" "a\"b" "a\"b")`, `"a\"b and \"a\\\"b\""`},
+	evalTestCase{`(format #f "~a" '("x" y 1))`, `"(x y 1)"`},
+	evalTestCase{`(format #f "~s" '("x" y 1))`, `"(\"x\" y 1)"`},
+	evalTestCase{`(format #f "~d~%~~" 42)`, `"42\n~"`},
+	evalTestCase{`(format #f "[~5a][~5@a]" "ab" "ab")`, `"[ab   ][   ab]"`},
+	evalTestCase{`(format #f "~5d|~5,'0d|~@d" 42 42 42)`, `"   42|00042|+42"`},
+	evalTestCase{`(format #f "~b ~o ~x ~36r" 10 10 255 35)`, `"1010 12 ff z"`},
+	evalTestCase{`(format #f "~16,4,'0r" 255)`, `"00ff"`},
+	evalTestCase{`(format #f "~d" "not a number")`, `"not a number"`},
+	evalTestCase{`(try (format #f "~a ~a" 1) error-message)`, `"format: too few arguments for \"~a ~a\""`},
+	evalTestCase{`(try (format #f "~q" 1) error-message)`, `"format: unknown directive ~q"`},
+	evalTestCase{`(format #f "~3%~2~")`, `"\n\n\n~~"`},
+	evalTestCase{`(try (format #f "~-1%") error-message)`, `"format: parameter out of range: -1"`},
+	evalTestCase{`(try (format #f "~1000000000~") error-message)`, `"format: parameter out of range: 1000000000"`},
+	evalTestCase{`(try (format #f "~-5a" 1) error-message)`, `"format: parameter out of range: -5"`},
+}
+
+func TestFormat(t *testing.T) {
+	env := NewEnv()
+	for _, tc := range formatStringTestCases {
+		if expr := env.EvalString(tc.input); expr.String() != tc.output {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+		}
+	}
+	buffer := new(bytes.Buffer)
+	env.SetOutput(buffer)
+	env.EvalString(`(format #t "~a=~d~%" "x" 1) (format (current-output-port) "~s" "y")`)
+	if buffer.String() != "x=1\n\"y\"" {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", buffer.String(), "x=1\n\"y\"")
+	}
+}
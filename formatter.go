@@ -0,0 +1,53 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// FormatSource reads yall source from input and returns it in canonical
+// form, with every expression laid out by the pretty printer in width
+// columns.  Comments are kept, and blank lines between top-level
+// expressions are collapsed into one.  Formatting its own output gives the
+// same result.
+func FormatSource(input io.Reader, width int) (formatted []byte, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			serr, ok := e.(*SyntaxError)
+			if !ok {
+				panic(e)
+			}
+			formatted, err = nil, serr
+		}
+	}()
+	r := &reader{input: bufio.NewReader(input), keepComments: true}
+	buffer := new(bytes.Buffer)
+	for first := true; ; first = false {
+		newlines := r.skipSpace()
+		expr, _, err := r.readTokens(false)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if comment, ok := expr.(*Comment); ok && comment.trailing && !first {
+			buffer.WriteString(" ")
+		} else if !first {
+			buffer.WriteString("\n")
+			if 1 < newlines {
+				buffer.WriteString("\n")
+			}
+		}
+		buffer.WriteString(Pretty(expr, width))
+	}
+	if 0 < buffer.Len() {
+		buffer.WriteString("\n")
+	}
+	return buffer.Bytes(), nil
+}
@@ -0,0 +1,44 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"strings"
+	"testing"
+)
+
+type formatTestCase struct {
+	input  string
+	output string
+}
+
+var formatTestCases = []formatTestCase{
+	formatTestCase{"", ""},
+	formatTestCase{"(a   b)\n\n\n\n(c)", "(a b)\n\n(c)\n"},
+	formatTestCase{"(a)\n(b)", "(a)\n(b)\n"},
+	formatTestCase{"; header\n(a) ; trailing\n(b)", "; header\n(a) ; trailing\n(b)\n"},
+	formatTestCase{"(defn (f x)\n; doc\n(g x))", "(defn (f x)\n  ; doc\n  (g x))\n"},
+	formatTestCase{"(defn (f x) (g x) ; done\n)", "(defn (f x)\n  (g x) ; done\n  )\n"},
+	formatTestCase{"(f a;c\nb)", "(f a ;c\n   b)\n"},
+	formatTestCase{"(a) ; c\n\n(b)", "(a) ; c\n\n(b)\n"},
+	formatTestCase{"\"a;b\" 'c", "\"a;b\"\n'c\n"},
+}
+
+func TestFormatSource(t *testing.T) {
+	for _, tc := range formatTestCases {
+		formatted, err := FormatSource(strings.NewReader(tc.input), DefaultWidth)
+		if err != nil {
+			t.Errorf("input: [[%v]], ERROR: [[%v]]", tc.input, err)
+			continue
+		}
+		if string(formatted) != tc.output {
+			t.Errorf("expected: [[%v]], received: [[%v]]", tc.output, string(formatted))
+		}
+		again, _ := FormatSource(strings.NewReader(string(formatted)), DefaultWidth)
+		if string(again) != string(formatted) {
+			t.Errorf("not idempotent: [[%v]] became [[%v]]", string(formatted), string(again))
+		}
+	}
+}
@@ -4,9 +4,65 @@
 
 package yall
 
-import (
-	"fmt"
-)
+func stringArg(name string, expr Expr) string {
+	if s, ok := expr.(*String); ok {
+		return s.value
+	}
+	panic(NewRuntimeError(name + " requires a string, but got " + printable(expr)))
+}
+
+func functionArg(name string, expr Expr) *Function {
+	if function, ok := expr.(*Function); ok {
+		return function
+	}
+	panic(NewRuntimeError(name + " requires a function, but got " + printable(expr)))
+}
+
+// printable returns expr.String(), or "nothing" for a missing argument.
+func printable(expr Expr) string {
+	if expr == nil {
+		return "nothing"
+	}
+	return expr.String()
+}
+
+// toFloat returns the value of the number expr as a float64.
+func toFloat(name string, expr Expr) float64 {
+	switch n := expr.(type) {
+	case *Integer:
+		return float64(n.value)
+	case *Float:
+		return n.value
+	}
+	panic(NewRuntimeError(name + " requires numbers, but got " + printable(expr)))
+}
+
+// numberEqual reports whether the numbers a and b are equal.  Integers
+// are compared exactly, and converted to floats only to compare them with
+// floats.
+func numberEqual(name string, a Expr, b Expr) bool {
+	if i, ok := a.(*Integer); ok {
+		if j, ok := b.(*Integer); ok {
+			return i.value == j.value
+		}
+	}
+	return toFloat(name, a) == toFloat(name, b)
+}
+
+// arithmetic folds args into result with iop while all of them are
+// integers, and with fop once a float is involved.
+func arithmetic(name string, result Expr, args *Cell, iop func(int, int) int, fop func(float64, float64) float64) Expr {
+	for ; Empty != args; args = args.Cdr() {
+		a, aok := result.(*Integer)
+		b, bok := args.Car().(*Integer)
+		if aok && bok {
+			result = NewInteger(iop(a.value, b.value))
+		} else {
+			result = NewFloat(fop(toFloat(name, result), toFloat(name, args.Car())))
+		}
+	}
+	return result
+}
 
 var builtinFunctions = map[string]func(*Cell) Expr{
 
@@ -32,65 +88,56 @@ var builtinFunctions = map[string]func(*Cell) Expr{
 	},
 
 	"+": func(args *Cell) Expr {
-		result := 0
-		for Empty != args {
-			result += args.Car().(*Integer).Value()
-			args = args.Cdr()
-		}
-		return NewInteger(result)
+		return arithmetic("+", NewInteger(0), args, func(a, b int) int {
+			return a + b
+		}, func(a, b float64) float64 {
+			return a + b
+		})
 	},
 
 	"-": func(args *Cell) Expr {
 		if Empty == args {
 			panic(NewRuntimeError("Too few arguments to minus, at least 1 required"))
 		}
-		i, iok := args.Car().(*Integer)
-		if !iok {
-			panic(NewRuntimeError("Minus requires integers"))
-		}
-		result := i.Value()
+		result := args.Car()
 		if Empty == args.Cdr() {
-			return NewInteger(result * -1)
-		}
-		for cell := args.Cdr(); cell != Empty; cell = cell.Cdr() {
-			i, iok := cell.Car().(*Integer)
-			if !iok {
-				panic(NewRuntimeError("Minus requires integers"))
-			}
-			result -= i.Value()
+			result = NewInteger(0)
+		} else {
+			args = args.Cdr()
 		}
-		return NewInteger(result)
+		return arithmetic("-", result, args, func(a, b int) int {
+			return a - b
+		}, func(a, b float64) float64 {
+			return a - b
+		})
 	},
 
 	"*": func(args *Cell) Expr {
-		result := 1
-		for Empty != args {
-			result *= args.Car().(*Integer).Value()
-			args = args.Cdr()
-		}
-		return NewInteger(result)
+		return arithmetic("*", NewInteger(1), args, func(a, b int) int {
+			return a * b
+		}, func(a, b float64) float64 {
+			return a * b
+		})
 	},
 
 	"/": func(args *Cell) Expr {
 		if Empty == args {
 			panic(NewRuntimeError("Too few arguments to '/', at least 1 required"))
 		}
-		i, iok := args.Car().(*Integer)
-		if !iok {
-			panic(NewRuntimeError("'/' requires integers"))
-		}
-		result := i.Value()
+		result := args.Car()
 		if Empty == args.Cdr() {
-			return NewInteger(1 / result)
+			result = NewInteger(1)
+		} else {
+			args = args.Cdr()
 		}
-		for cell := args.Cdr(); cell != Empty; cell = cell.Cdr() {
-			i, iok := cell.Car().(*Integer)
-			if !iok {
-				panic(NewRuntimeError("'/' requires integers"))
+		return arithmetic("/", result, args, func(a, b int) int {
+			if b == 0 {
+				panic(NewRuntimeError("Division by zero"))
 			}
-			result /= i.Value()
-		}
-		return NewInteger(result)
+			return a / b
+		}, func(a, b float64) float64 {
+			return a / b
+		})
 	},
 
 	"type-of": func(args *Cell) Expr {
@@ -100,15 +147,25 @@ var builtinFunctions = map[string]func(*Cell) Expr{
 		return typeOf(args.Car())
 	},
 
-	"println": func(args *Cell) Expr {
-		args.Each(func(expr Expr) {
-			if str, ok := expr.(*String); ok {
-				fmt.Println(str.value)
-			} else {
-				fmt.Println(expr.String())
-			}
-		})
-		return True
+	"error": func(args *Cell) Expr {
+		panic(NewRuntimeError(stringArg("error", args.Car())))
+	},
+
+	"error-message": func(args *Cell) Expr {
+		switch err := args.Car().(type) {
+		case *RuntimeError:
+			return NewString(err.message)
+		case *SyntaxError:
+			return NewString(err.message)
+		}
+		panic(NewRuntimeError("error-message requires an error, but got " + printable(args.Car())))
+	},
+
+	"eof-object?": func(args *Cell) Expr {
+		if EOF == args.Car() {
+			return True
+		}
+		return False
 	},
 
 	"empty?": func(args *Cell) Expr {
@@ -126,17 +183,9 @@ var builtinFunctions = map[string]func(*Cell) Expr{
 		if Empty == args {
 			panic(NewRuntimeError("Too few arguments to '=', at least 1 required"))
 		}
-		i, iok := args.Car().(*Integer)
-		if !iok {
-			panic(NewRuntimeError("'=' requires integers"))
-		}
-		value := i.Value()
+		toFloat("=", args.Car()) // raises an error unless it is a number
 		for cell := args.Cdr(); cell != Empty; cell = cell.Cdr() {
-			i, iok := cell.Car().(*Integer)
-			if !iok {
-				panic(NewRuntimeError("'=' requires integers"))
-			}
-			if (value != i.Value()) {
+			if !numberEqual("=", args.Car(), cell.Car()) {
 				return False
 			}
 		}
@@ -0,0 +1,132 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"reflect"
+)
+
+// GoValue is an opaque handle to a Go value.  Scripts can call its methods
+// with (. obj Method args...) and read its fields with (.- obj Field).
+type GoValue struct {
+	value reflect.Value
+}
+
+func NewGoValue(v interface{}) *GoValue {
+	return &GoValue{reflect.ValueOf(v)}
+}
+
+func (g *GoValue) String() string {
+	return "<go " + g.typeName() + ">"
+}
+
+// typeName returns the name of the type of the value g holds, or nil if g
+// was made from nil.
+func (g *GoValue) typeName() string {
+	if !g.value.IsValid() {
+		return "nil"
+	}
+	return g.value.Type().String()
+}
+
+// Value returns the Go value that g holds.
+func (g *GoValue) Value() interface{} {
+	if !g.value.IsValid() {
+		return nil
+	}
+	return g.value.Interface()
+}
+
+// goResult converts a result of a Go function into an expression.  Unlike
+// FromGo, it keeps pointers, functions, channels and interfaces with methods
+// as handles, so that scripts can pass them back to Go.
+func goResult(v reflect.Value) (Expr, error) {
+	if v.Type().Implements(exprType) {
+		return fromValue(v)
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		if v.IsNil() {
+			return Empty, nil
+		}
+		return &GoValue{v}, nil
+	case reflect.Interface:
+		if v.IsNil() {
+			return Empty, nil
+		}
+		if 0 < v.NumMethod() {
+			return &GoValue{v.Elem()}, nil
+		}
+		return goResult(v.Elem())
+	}
+	return fromValue(v)
+}
+
+func goValueArg(name string, expr Expr) *GoValue {
+	if g, ok := expr.(*GoValue); ok {
+		return g
+	}
+	panic(NewRuntimeError(name + " requires a Go value, but got " + printable(expr)))
+}
+
+func memberName(name string, expr Expr) string {
+	if symbol, ok := expr.(*Symbol); ok {
+		return symbol.name
+	}
+	panic(NewRuntimeError(name + " requires a member name, but got " + printable(expr)))
+}
+
+// goSpecialForms are the special forms that access Go values.
+var goSpecialForms = map[string]func(*Env, *Cell) Expr{
+
+	// (. obj Method args...) calls Method of obj with args.
+	".": func(env *Env, args *Cell) Expr {
+		g := goValueArg(".", env.Eval(args.Car()))
+		name := memberName(".", args.Cadr())
+		if !g.value.IsValid() {
+			panic(NewRuntimeError("No method " + name + " in " + g.String()))
+		}
+		method := g.value.MethodByName(name)
+		if !method.IsValid() && g.value.Kind() != reflect.Ptr {
+			// Methods with pointer receivers need an addressable value.
+			// Calling them on a copy would lose what they change.
+			if g.value.CanAddr() {
+				method = g.value.Addr().MethodByName(name)
+			} else if _, ok := reflect.PtrTo(g.value.Type()).MethodByName(name); ok {
+				panic(NewRuntimeError("Method " + name + " of " + g.String() + " requires a pointer"))
+			}
+		}
+		if !method.IsValid() {
+			panic(NewRuntimeError("No method " + name + " in " + g.String()))
+		}
+		return callGo(env.currentContext(), name, method, env.EvalEach(args.Cdr().Cdr()))
+	},
+
+	// (.- obj Field) returns Field of obj, which must be a struct or a
+	// pointer to one.
+	".-": func(env *Env, args *Cell) Expr {
+		g := goValueArg(".-", env.Eval(args.Car()))
+		name := memberName(".-", args.Cadr())
+		v := g.value
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			if v.IsNil() {
+				panic(NewRuntimeError("Can't read " + name + " of nil " + g.String()))
+			}
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			panic(NewRuntimeError("No field " + name + " in " + g.String()))
+		}
+		field := v.FieldByName(name)
+		if !field.IsValid() || !field.CanInterface() {
+			panic(NewRuntimeError("No field " + name + " in " + g.String()))
+		}
+		expr, err := goResult(field)
+		if err != nil {
+			panic(NewRuntimeError(".-: " + err.Error()))
+		}
+		return expr
+	},
+}
@@ -0,0 +1,107 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+type goValueTestRequest struct {
+	Method string
+	Path   string
+	Header map[string]string
+	Next   *goValueTestRequest
+	secret string
+}
+
+func (req *goValueTestRequest) HeaderValue(key string) (string, error) {
+	if value, ok := req.Header[key]; ok {
+		return value, nil
+	}
+	return "", errors.New("no header " + key)
+}
+
+func (req goValueTestRequest) Describe(verbose bool) string {
+	if verbose {
+		return req.Method + " " + req.Path
+	}
+	return req.Method
+}
+
+func (req *goValueTestRequest) SetPath(path string) {
+	req.Path = path
+}
+
+func TestGoValue(t *testing.T) {
+	env := NewEnv()
+	req := &goValueTestRequest{Method: "GET", Path: "/", Header: map[string]string{"Host": "example.com"}}
+	req.Next = &goValueTestRequest{Method: "POST"}
+	env.Intern(NewSymbol("req"), NewGoValue(req))
+	env.Intern(NewSymbol("reader"), NewGoValue(strings.NewReader("abc")))
+	for _, tc := range []evalTestCase{
+		evalTestCase{"(.- req Method)", "\"GET\""},
+		evalTestCase{"(. req Describe #t)", "\"GET /\""},
+		evalTestCase{"(. req SetPath \"/index\")", "#t"},
+		evalTestCase{"(.- req Path)", "\"/index\""},
+		evalTestCase{"(. req HeaderValue \"Host\")", "\"example.com\""},
+		evalTestCase{"(try (. req HeaderValue \"Accept\") error-message)", "\"HeaderValue: no header Accept\""},
+		evalTestCase{"(.- (.- req Next) Method)", "\"POST\""},
+		evalTestCase{"(.- req Header)", "(object (\"Host\" \"example.com\"))"},
+		evalTestCase{"(type-of req)", "<*yall.goValueTestRequest>"},
+		evalTestCase{"(type-of (.- req Next))", "<*yall.goValueTestRequest>"},
+		evalTestCase{"(. reader Len)", "3"},
+		evalTestCase{"(try (. req Missing) error-message)", "\"No method Missing in <go *yall.goValueTestRequest>\""},
+		evalTestCase{"(try (.- req secret) error-message)", "\"No field secret in <go *yall.goValueTestRequest>\""},
+	} {
+		if expr := env.EvalString(tc.input); expr.String() != tc.output {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+		}
+	}
+	if req.Path != "/index" {
+		t.Errorf("SetPath did not modify the request")
+	}
+}
+
+func TestGoValueReceiver(t *testing.T) {
+	env := NewEnv()
+	env.Intern(NewSymbol("req"), NewGoValue(goValueTestRequest{Method: "GET", Path: "/"}))
+	env.Intern(NewSymbol("none"), NewGoValue(nil))
+	for _, tc := range []evalTestCase{
+		evalTestCase{"(. req Describe #t)", "\"GET /\""},
+		evalTestCase{"(try (. req SetPath \"/index\") error-message)",
+			"\"Method SetPath of <go yall.goValueTestRequest> requires a pointer\""},
+		evalTestCase{"(.- req Path)", "\"/\""},
+		evalTestCase{"none", "<go nil>"},
+		evalTestCase{"(type-of none)", "<nil>"},
+		evalTestCase{"(try (. none String) error-message)", "\"No method String in <go nil>\""},
+		evalTestCase{"(try (.- none Path) error-message)", "\"No field Path in <go nil>\""},
+	} {
+		if expr := env.EvalString(tc.input); expr.String() != tc.output {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+		}
+	}
+	if value := NewGoValue(nil).Value(); value != nil {
+		t.Errorf("Received [[%v]] when expecting nil", value)
+	}
+}
+
+func TestGoValueArgument(t *testing.T) {
+	env := NewEnv()
+	env.Register("new-request", func(method string) *goValueTestRequest {
+		return &goValueTestRequest{Method: method}
+	})
+	env.Register("method-of", func(req *goValueTestRequest) string {
+		return req.Method
+	})
+	if expr := env.EvalString("(method-of (new-request \"PUT\"))"); expr.String() != "\"PUT\"" {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", expr, "\"PUT\"")
+	}
+	var req *goValueTestRequest
+	if err := ToGo(env.EvalString("(new-request \"DELETE\")"), &req); err != nil || req.Method != "DELETE" {
+		t.Errorf("Received [[%v]], ERROR: [[%v]]", req, err)
+	}
+}
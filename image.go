@@ -0,0 +1,443 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// An image is a sequence of forms written with write and read back with
+// the standard readtable.  It starts with (yall-image version), followed by
+// the frames captured by closures, the closures, the bindings of every
+// frame, the modules and the files loaded by require:
+//
+//	(frame id parent-id)
+//	(closure id function|macro "name" frame-id lambda-list body)
+//	(binding frame-id "name" value)
+//	(module "name" ("export" value)...)
+//	(loaded "path")
+//
+// The global frame is frame 0.  Values are encoded as (data expr) for
+// expressions that read back as themselves, (cons car cdr) for lists
+// holding other values, (closure id), and (builtin "name"),
+// (special-form "name"), (bool #t), (eof) or (type "name") for values
+// found again by name when the image is loaded.
+
+// imageVersion is the version of the image format written by SaveImage.
+const imageVersion = 1
+
+type imageWriter struct {
+	w        *bufio.Writer
+	frames   map[*Env]int
+	closures map[Expr]int
+	// frameList and closureList hold the frames and closures in the order
+	// of their ids, starting from 1.
+	frameList   []*Env
+	closureList []Expr
+	root        *Env
+}
+
+func tagged(tag string, exprs ...Expr) *Cell {
+	return NewCell(NewSymbol(tag), listOf(exprs))
+}
+
+// isData reports whether expr reads back as itself when written.
+func isData(expr Expr) bool {
+	switch e := expr.(type) {
+	case *Integer, *Float, *String, *Symbol:
+		return true
+	case *Cell:
+		for ; e != Empty; e = e.cdr {
+			if !isData(e.car) {
+				return false
+			}
+		}
+		return true
+	case *Quoted:
+		return isData(e.expr)
+	case *Quasiquoted:
+		return isData(e.expr)
+	case *Unquoted:
+		return isData(e.expr)
+	case *SplicingUnquoted:
+		return isData(e.expr)
+	}
+	return false
+}
+
+func (iw *imageWriter) frameID(frame *Env) int {
+	if frame == iw.root {
+		return 0
+	}
+	if id, ok := iw.frames[frame]; ok {
+		return id
+	}
+	if frame.parent == nil {
+		panic(NewRuntimeError("Cannot save a closure of another environment"))
+	}
+	parent := iw.frameID(frame.parent)
+	iw.frameList = append(iw.frameList, frame)
+	id := len(iw.frameList)
+	iw.frames[frame] = id
+	iw.writeForm(tagged("frame", NewInteger(id), NewInteger(parent)))
+	return id
+}
+
+func (iw *imageWriter) closureID(expr Expr, c *closure) int {
+	if id, ok := iw.closures[expr]; ok {
+		return id
+	}
+	iw.closureList = append(iw.closureList, expr)
+	id := len(iw.closureList)
+	iw.closures[expr] = id
+	iw.frameID(c.env)
+	return id
+}
+
+func (iw *imageWriter) encode(expr Expr) Expr {
+	if isData(expr) {
+		return tagged("data", expr)
+	}
+	switch e := expr.(type) {
+	case *Cell:
+		return tagged("cons", iw.encode(e.car), iw.encode(e.cdr))
+	case *Bool:
+		return tagged("bool", NewSymbol(e.String()))
+	case *eofObject:
+		return tagged("eof")
+	case *Type:
+		return tagged("type", NewString(e.name))
+	case *SpecialForm:
+		return tagged("special-form", NewString(e.name))
+	case *Function:
+		if e.key != "" {
+			return tagged("builtin", NewString(e.key))
+		}
+		if e.closure != nil {
+			return tagged("closure", NewInteger(iw.closureID(e, e.closure)))
+		}
+	case *Macro:
+		if e.closure != nil {
+			return tagged("closure", NewInteger(iw.closureID(e, e.closure)))
+		}
+	}
+	panic(NewRuntimeError("Cannot save " + printable(expr) + " in an image"))
+}
+
+func (iw *imageWriter) writeForm(form Expr) {
+	iw.w.WriteString(form.String())
+	iw.w.WriteString("\n")
+}
+
+// isInstalled reports whether value is the builtin installed as name, which
+// every Env it could be loaded into has already.
+func isInstalled(name string, value Expr) bool {
+	switch v := value.(type) {
+	case *Function:
+		return v.key == name
+	case *SpecialForm:
+		return v.name == name
+	case *Bool:
+		return v.String() == name
+	}
+	return false
+}
+
+func sortedNames(env *Env) []string {
+	names := env.names()
+	sort.Strings(names)
+	return names
+}
+
+// SaveImage writes the global environment of env to w, so that LoadImage
+// can restore it.  Closures are saved with the frames they captured, and
+// builtins by their names.  The reader macros are not saved.  Ports,
+// channels and Go values cannot be saved: if a binding or an export holds
+// one, an error is returned and what was written to w is not an image.
+func (env *Env) SaveImage(w io.Writer) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(error)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	iw := &imageWriter{
+		w:        bufio.NewWriter(w),
+		frames:   make(map[*Env]int),
+		closures: make(map[Expr]int),
+		root:     env.root(),
+	}
+	iw.writeForm(tagged("yall-image", NewInteger(imageVersion)))
+	// Encoding values finds more frames and closures, and the frames are
+	// written as they are found.  The rest is collected and written last.
+	forms := []Expr{}
+	for _, name := range sortedNames(iw.root) {
+		if value, ok := iw.root.lookup(name); ok && !isInstalled(name, value) {
+			forms = append(forms, tagged("binding", NewInteger(0), NewString(name), iw.encode(value)))
+		}
+	}
+	iw.root.lock.RLock()
+	modules := make([]*Module, 0, len(iw.root.modules))
+	for _, module := range iw.root.modules {
+		modules = append(modules, module)
+	}
+	loaded := make([]string, 0, len(iw.root.loaded))
+	for path := range iw.root.loaded {
+		loaded = append(loaded, path)
+	}
+	iw.root.lock.RUnlock()
+	sort.Slice(modules, func(i, j int) bool {
+		return modules[i].name < modules[j].name
+	})
+	sort.Strings(loaded)
+	for _, module := range modules {
+		exports := []Expr{NewSymbol("module"), NewString(module.name)}
+		for _, name := range module.names() {
+			value, _ := module.lookup(name)
+			exports = append(exports, entry(NewString(name), iw.encode(value)))
+		}
+		forms = append(forms, listOf(exports))
+	}
+	for _, path := range loaded {
+		forms = append(forms, tagged("loaded", NewString(path)))
+	}
+	for i := 0; i < len(iw.frameList) || i < len(iw.closureList); i++ {
+		if i < len(iw.closureList) {
+			var form Expr
+			switch c := iw.closureList[i].(type) {
+			case *Function:
+				form = tagged("closure", NewInteger(i+1), NewSymbol("function"), NewString(c.name),
+					NewInteger(iw.frameID(c.closure.env)), iw.encode(c.closure.lambdaList), iw.encode(c.closure.body))
+			case *Macro:
+				form = tagged("closure", NewInteger(i+1), NewSymbol("macro"), NewString(c.name),
+					NewInteger(iw.frameID(c.closure.env)), iw.encode(c.closure.lambdaList), iw.encode(c.closure.body))
+			}
+			forms = append(forms, form)
+		}
+		if i < len(iw.frameList) {
+			frame := iw.frameList[i]
+			for _, name := range sortedNames(frame) {
+				if value, ok := frame.lookup(name); ok {
+					forms = append(forms, tagged("binding", NewInteger(i+1), NewString(name), iw.encode(value)))
+				}
+			}
+		}
+	}
+	for _, form := range forms {
+		iw.writeForm(form)
+	}
+	return iw.w.Flush()
+}
+
+type imageReader struct {
+	env      *Env
+	frames   map[int]*Env
+	closures map[int]*closure
+	values   map[int]Expr
+}
+
+func imageError(form Expr) *RuntimeError {
+	return NewRuntimeError("Invalid image: " + printable(form))
+}
+
+func intField(form *Cell, i int) int {
+	c := form
+	for ; 0 < i && c != Empty; i-- {
+		c = c.cdr
+	}
+	if integer, ok := c.Car().(*Integer); ok {
+		return integer.value
+	}
+	panic(imageError(form))
+}
+
+func stringField(form *Cell, i int) string {
+	c := form
+	for ; 0 < i && c != Empty; i-- {
+		c = c.cdr
+	}
+	if s, ok := c.Car().(*String); ok {
+		return s.value
+	}
+	panic(imageError(form))
+}
+
+func (ir *imageReader) builtin(name string) Expr {
+	root := ir.env.root()
+	if value, ok := root.lookup(name); ok {
+		return value
+	}
+	if value, ok := root.lookupQualified(name); ok {
+		return value
+	}
+	panic(NewRuntimeError("Cannot load image: unknown builtin " + name))
+}
+
+func (ir *imageReader) decode(expr Expr) Expr {
+	form, ok := expr.(*Cell)
+	if !ok || form == Empty {
+		panic(imageError(expr))
+	}
+	tag, _ := form.car.(*Symbol)
+	if tag == nil {
+		panic(imageError(expr))
+	}
+	switch tag.name {
+	case "data":
+		return form.Cadr()
+	case "cons":
+		if cdr, ok := ir.decode(form.Caddr()).(*Cell); ok {
+			return NewCell(ir.decode(form.Cadr()), cdr)
+		}
+	case "bool":
+		return ir.builtin(form.Cadr().String())
+	case "eof":
+		return EOF
+	case "type":
+		return NewType(stringField(form, 1))
+	case "special-form":
+		if form, ok := ir.builtin(stringField(form, 1)).(*SpecialForm); ok {
+			return form
+		}
+	case "builtin":
+		if function, ok := ir.builtin(stringField(form, 1)).(*Function); ok {
+			return function
+		}
+	case "closure":
+		if value, ok := ir.values[intField(form, 1)]; ok {
+			return value
+		}
+	}
+	panic(imageError(expr))
+}
+
+// LoadImage restores the global environment saved by SaveImage into env,
+// which should be fresh.  Builtins are linked by name to those of env, so
+// Go functions registered before saving must be registered again before
+// loading.  An image of another version is refused.
+func (env *Env) LoadImage(r io.Reader) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(error)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	forms, err := NewReader(r).ReadAll()
+	if err != nil {
+		return err
+	}
+	if len(forms) == 0 {
+		return NewRuntimeError("Invalid image: empty")
+	}
+	header, ok := forms[0].(*Cell)
+	if !ok || header == Empty || header.car.String() != "yall-image" {
+		return imageError(forms[0])
+	}
+	if version := intField(header, 1); version != imageVersion {
+		return NewRuntimeError("Cannot load image: unsupported version " + strconv.Itoa(version))
+	}
+	root := env.root()
+	ir := &imageReader{
+		env:      env,
+		frames:   map[int]*Env{0: root},
+		closures: make(map[int]*closure),
+		values:   make(map[int]Expr),
+	}
+	// Frames and closures are made first, so that values can refer to
+	// them in any order.
+	type binding struct {
+		frame *Env
+		name  string
+		value Expr
+	}
+	bindings := []binding{}
+	modules := []*Module{}
+	for _, expr := range forms[1:] {
+		form, ok := expr.(*Cell)
+		if !ok || form == Empty {
+			return imageError(expr)
+		}
+		switch form.car.String() {
+		case "frame":
+			parent, ok := ir.frames[intField(form, 2)]
+			if !ok {
+				return imageError(form)
+			}
+			ir.frames[intField(form, 1)] = parent.Derive()
+		case "closure":
+			frame, ok := ir.frames[intField(form, 4)]
+			if !ok {
+				return imageError(form)
+			}
+			c := &closure{env: frame}
+			id := intField(form, 1)
+			ir.closures[id] = c
+			if form.Caddr().String() == "macro" {
+				ir.values[id] = newClosureMacro(stringField(form, 3), c)
+			} else {
+				ir.values[id] = newClosureFunction(stringField(form, 3), c)
+			}
+		}
+	}
+	for _, expr := range forms[1:] {
+		form := expr.(*Cell)
+		switch form.car.String() {
+		case "closure":
+			c := ir.closures[intField(form, 1)]
+			fields := form.Cdr().Cdr().Cdr().Cdr().Cdr()
+			lambdaList, ok := ir.decode(fields.Car()).(*Cell)
+			body, bok := ir.decode(fields.Cadr()).(*Cell)
+			if !ok || !bok {
+				return imageError(form)
+			}
+			c.lambdaList, c.body = lambdaList, body
+		case "binding":
+			frame, ok := ir.frames[intField(form, 1)]
+			if !ok {
+				return imageError(form)
+			}
+			bindings = append(bindings, binding{frame, stringField(form, 2), ir.decode(form.Cdr().Cdr().Cdr().Car())})
+		case "module":
+			module := newModule(stringField(form, 1))
+			form.Cdr().Cdr().Each(func(export Expr) {
+				cell, ok := export.(*Cell)
+				if !ok || cell == Empty {
+					panic(imageError(form))
+				}
+				module.exports[stringField(cell, 0)] = ir.decode(cell.Cadr())
+			})
+			modules = append(modules, module)
+		case "loaded":
+			path := stringField(form, 1)
+			root.lock.Lock()
+			if root.loaded == nil {
+				root.loaded = make(map[string]bool)
+			}
+			root.loaded[path] = true
+			root.lock.Unlock()
+		case "frame":
+		default:
+			return imageError(form)
+		}
+	}
+	for _, b := range bindings {
+		b.frame.lock.Lock()
+		b.frame.values[b.name] = b.value
+		b.frame.lock.Unlock()
+	}
+	for _, module := range modules {
+		env.defineModule(module)
+	}
+	return nil
+}
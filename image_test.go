@@ -0,0 +1,85 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestImage(t *testing.T) {
+	env := NewEnv()
+	env.EvalString(`
+(def greeting "hello, \"world\"")
+(def data '(1 2.5 sym (nested "list")))
+(def flags (list #t #f car))
+(defn (make-counter)
+  (def n 0)
+  (fn () (inc! n)))
+(def counter (make-counter))
+(counter)
+(defmacro (twice x) (list '+ x x))
+(module tally (export next)
+  (def n 10)
+  (defn (next) (inc! n)))
+(tally/next)`)
+	image := new(bytes.Buffer)
+	if err := env.SaveImage(image); err != nil {
+		t.Fatalf("SaveImage: %v", err)
+	}
+	if !strings.HasPrefix(image.String(), "(yall-image 1)\n") {
+		t.Errorf("Received [[%v]] at the start of the image", image.String()[:20])
+	}
+	restored, err := NewEnvWithOptions(EnvOptions{NoPrelude: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := restored.LoadImage(bytes.NewReader(image.Bytes())); err != nil {
+		t.Fatalf("LoadImage: %v", err)
+	}
+	for _, tc := range []evalTestCase{
+		evalTestCase{"greeting", "\"hello, \\\"world\\\"\""},
+		evalTestCase{"data", "(1 2.5 sym (nested \"list\"))"},
+		evalTestCase{"flags", "(#t #f <function car>)"},
+		evalTestCase{"(counter)", "2"},
+		evalTestCase{"(counter)", "3"},
+		evalTestCase{"((make-counter))", "1"},
+		evalTestCase{"(twice (counter))", "9"},
+		evalTestCase{"(tally/next)", "12"},
+		evalTestCase{"(map (fn (x) (* x 2)) '(1 2))", "(2 4)"},
+	} {
+		if expr := restored.EvalString(tc.input); expr.String() != tc.output {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+		}
+	}
+	for _, tc := range []evalTestCase{
+		evalTestCase{"(counter)", "2"},
+		evalTestCase{"(tally/next)", "12"},
+	} {
+		if expr := env.EvalString(tc.input); expr.String() != tc.output {
+			t.Errorf("saved: %v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+		}
+	}
+}
+
+func TestImageErrors(t *testing.T) {
+	env := NewEnv()
+	env.EvalString("(def port (open-output-string))")
+	if err := env.SaveImage(new(bytes.Buffer)); err == nil || err.Error() != "*** ERROR: Cannot save <output-port> in an image" {
+		t.Errorf("Received [[%v]] when saving a port", err)
+	}
+	for _, tc := range []evalTestCase{
+		evalTestCase{"(yall-image 2)", "*** ERROR: Cannot load image: unsupported version 2"},
+		evalTestCase{"", "*** ERROR: Invalid image: empty"},
+		evalTestCase{"(yall-image 1) (binding 3 \"x\" (data 1))", "*** ERROR: Invalid image: (binding 3 \"x\" (data 1))"},
+		evalTestCase{"(yall-image 1) (binding 0 \"x\" (builtin \"no-such\"))", "*** ERROR: Cannot load image: unknown builtin no-such"},
+	} {
+		err := NewEnv().LoadImage(strings.NewReader(tc.input))
+		if err == nil || err.Error() != tc.output {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, err, tc.output)
+		}
+	}
+}
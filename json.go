@@ -0,0 +1,314 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// JSON values map to expressions as follows:
+//
+//	object         (object (key value)...), with string keys
+//	array          a list
+//	number         an Integer if it is integral, a Float otherwise
+//	string         a String
+//	true, false    True, False
+//	null           the symbol null
+//
+// Other symbols become strings, and the keys of objects may also be
+// symbols.  A list is only converted to an object if it starts with the
+// symbol object.
+
+// jsonObject is the symbol that starts the expression of a JSON object.
+var jsonObject = NewSymbol("object")
+
+// jsonNull is the expression of the JSON null.
+var jsonNull = NewSymbol("null")
+
+// jsonFloatToExpr converts a JSON number to an Integer if it is integral
+// and exactly representable, and to a Float otherwise.
+func jsonFloatToExpr(f float64) Expr {
+	if f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+		return NewInteger(int(f))
+	}
+	return NewFloat(f)
+}
+
+func jsonNumberToExpr(n json.Number) (Expr, error) {
+	if i, err := n.Int64(); err == nil && int64(int(i)) == i {
+		return NewInteger(int(i)), nil
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return nil, err
+	}
+	return jsonFloatToExpr(f), nil
+}
+
+// JSONToExpr converts v, a value as decoded by encoding/json, into an
+// expression.  The keys of objects are sorted since maps have no order.
+func JSONToExpr(v interface{}) (Expr, error) {
+	switch value := v.(type) {
+	case nil:
+		return jsonNull, nil
+	case bool:
+		if value {
+			return True, nil
+		}
+		return False, nil
+	case float64:
+		return jsonFloatToExpr(value), nil
+	case json.Number:
+		return jsonNumberToExpr(value)
+	case string:
+		return NewString(value), nil
+	case []interface{}:
+		list := Empty
+		for i := len(value) - 1; 0 <= i; i-- {
+			expr, err := JSONToExpr(value[i])
+			if err != nil {
+				return nil, err
+			}
+			list = NewCell(expr, list)
+		}
+		return list, nil
+	case map[string]interface{}:
+		keys := make([]string, 0, len(value))
+		for key := range value {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		alist := Empty
+		for i := len(keys) - 1; 0 <= i; i-- {
+			expr, err := JSONToExpr(value[keys[i]])
+			if err != nil {
+				return nil, err
+			}
+			alist = NewCell(NewCell(NewString(keys[i]), NewCell(expr, Empty)), alist)
+		}
+		return NewCell(jsonObject, alist), nil
+	}
+	return nil, errors.New("Cannot convert JSON value to expression")
+}
+
+// alistKey returns the key of an alist entry, if entry is one.
+func alistKey(entry Expr) (string, bool) {
+	cell, ok := entry.(*Cell)
+	if !ok || Empty == cell || Empty == cell.cdr || Empty != cell.cdr.cdr {
+		return "", false
+	}
+	switch key := cell.car.(type) {
+	case *String:
+		return key.value, true
+	case *Symbol:
+		return key.name, true
+	}
+	return "", false
+}
+
+// jsonObjectEntries returns the entries of cell and true if cell is a JSON
+// object, and an error if some entry of it is not a (key value) list.
+func jsonObjectEntries(cell *Cell) (*Cell, bool, error) {
+	if symbol, ok := cell.car.(*Symbol); !ok || symbol.name != jsonObject.name {
+		return Empty, false, nil
+	}
+	for c := cell.cdr; c != Empty; c = c.cdr {
+		if _, ok := alistKey(c.car); !ok {
+			return nil, true, errors.New("Cannot convert to JSON: invalid object entry " + printable(c.car))
+		}
+	}
+	return cell.cdr, true, nil
+}
+
+// ExprToJSON converts expr into a value that encoding/json can encode.
+func ExprToJSON(expr Expr) (interface{}, error) {
+	switch e := expr.(type) {
+	case *Integer:
+		return e.value, nil
+	case *Float:
+		if math.IsNaN(e.value) || math.IsInf(e.value, 0) {
+			return nil, errors.New("Cannot convert to JSON: " + e.String())
+		}
+		return e.value, nil
+	case *String:
+		return e.value, nil
+	case *Symbol:
+		if e.name == jsonNull.name {
+			return nil, nil
+		}
+		return e.name, nil
+	case *Bool:
+		return e.value, nil
+	case *Cell:
+		if Empty == e {
+			return []interface{}{}, nil
+		}
+		entries, isObject, err := jsonObjectEntries(e)
+		if err != nil {
+			return nil, err
+		}
+		if isObject {
+			object := make(map[string]interface{})
+			for c := entries; c != Empty; c = c.cdr {
+				key, _ := alistKey(c.car)
+				value, err := ExprToJSON(c.car.(*Cell).Cadr())
+				if err != nil {
+					return nil, err
+				}
+				object[key] = value
+			}
+			return object, nil
+		}
+		array := []interface{}{}
+		for c := e; c != Empty; c = c.cdr {
+			value, err := ExprToJSON(c.car)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		return array, nil
+	}
+	return nil, errors.New("Cannot convert to JSON: " + printable(expr))
+}
+
+// ParseJSON parses a JSON text into an expression.  Unlike JSONToExpr, it
+// keeps the keys of objects in the order they appear in the text.
+func ParseJSON(data string) (Expr, error) {
+	decoder := json.NewDecoder(strings.NewReader(data))
+	decoder.UseNumber()
+	expr, err := parseJSONValue(decoder)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, errors.New("Unexpected data after JSON value")
+	}
+	return expr, nil
+}
+
+func parseJSONValue(decoder *json.Decoder) (Expr, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return JSONToExpr(token)
+	}
+	exprs := []Expr{}
+	for decoder.More() {
+		var key Expr
+		if delim == '{' {
+			k, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			key = NewString(k.(string))
+		}
+		value, err := parseJSONValue(decoder)
+		if err != nil {
+			return nil, err
+		}
+		if key != nil {
+			value = NewCell(key, NewCell(value, Empty))
+		}
+		exprs = append(exprs, value)
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, err
+	}
+	list := Empty
+	for i := len(exprs) - 1; 0 <= i; i-- {
+		list = NewCell(exprs[i], list)
+	}
+	if delim == '{' {
+		return NewCell(jsonObject, list), nil
+	}
+	return list, nil
+}
+
+// StringifyJSON returns the JSON text for expr.  Unlike encoding the result
+// of ExprToJSON, it keeps the keys of objects in the order of the entries.
+func StringifyJSON(expr Expr) (string, error) {
+	buffer := new(bytes.Buffer)
+	if err := writeJSON(buffer, expr); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+func writeJSON(buffer *bytes.Buffer, expr Expr) error {
+	cell, ok := expr.(*Cell)
+	if !ok || Empty == cell {
+		value, err := ExprToJSON(expr)
+		if err != nil {
+			return err
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		buffer.Write(data)
+		return nil
+	}
+	entries, object, err := jsonObjectEntries(cell)
+	if err != nil {
+		return err
+	}
+	if object {
+		buffer.WriteString("{")
+	} else {
+		entries = cell
+		buffer.WriteString("[")
+	}
+	for c := entries; c != Empty; c = c.cdr {
+		if c != entries {
+			buffer.WriteString(",")
+		}
+		value := c.car
+		if object {
+			key, _ := alistKey(c.car)
+			data, _ := json.Marshal(key)
+			buffer.Write(data)
+			buffer.WriteString(":")
+			value = c.car.(*Cell).Cadr()
+		}
+		if err := writeJSON(buffer, value); err != nil {
+			return err
+		}
+	}
+	if object {
+		buffer.WriteString("}")
+	} else {
+		buffer.WriteString("]")
+	}
+	return nil
+}
+
+var jsonFunctions = map[string]func(*Cell) Expr{
+
+	"json-parse": func(args *Cell) Expr {
+		expr, err := ParseJSON(stringArg("json-parse", args.Car()))
+		if err != nil {
+			panic(NewRuntimeError("json-parse: " + err.Error()))
+		}
+		return expr
+	},
+
+	"json-stringify": func(args *Cell) Expr {
+		s, err := StringifyJSON(args.Car())
+		if err != nil {
+			panic(NewRuntimeError("json-stringify: " + err.Error()))
+		}
+		return NewString(s)
+	},
+}
@@ -0,0 +1,73 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+var jsonTestCases = []evalTestCase{
+	evalTestCase{`{"b": [1, 2.5, "x"], "a": {"t": true, "f": false, "n": null}}`,
+		`(object ("b" (1 2.5 "x")) ("a" (object ("t" #t) ("f" #f) ("n" null))))`},
+	evalTestCase{`[]`, `()`},
+	evalTestCase{`{}`, `(object)`},
+	evalTestCase{`[["a", 1]]`, `(("a" 1))`},
+	evalTestCase{`-3`, `-3`},
+	evalTestCase{`1e3`, `1000`},
+	evalTestCase{`1.5e1`, `15`},
+	evalTestCase{`0.25`, `0.25`},
+	evalTestCase{`"a\"b"`, `"a\"b"`},
+}
+
+func TestParseJSON(t *testing.T) {
+	for _, tc := range jsonTestCases {
+		expr, err := ParseJSON(tc.input)
+		if err != nil {
+			t.Errorf("input: [[%v]], ERROR: [[%v]]", tc.input, err)
+		} else if expr.String() != tc.output {
+			t.Errorf("expected: [[%v]], received: [[%v]]", tc.output, expr)
+		}
+	}
+	for _, input := range []string{`{`, `[1,]`, `1 2`} {
+		if _, err := ParseJSON(input); err == nil {
+			t.Errorf("input: [[%v]], expected an error", input)
+		}
+	}
+}
+
+func TestStringifyJSON(t *testing.T) {
+	env := NewEnv()
+	for _, tc := range []evalTestCase{
+		evalTestCase{`(json-stringify (list 'object '("b" 1) (list 'a (list 1.5 "x" #t))))`, `"{\"b\":1,\"a\":[1.5,\"x\",true]}"`},
+		evalTestCase{`(json-stringify ())`, `"[]"`},
+		evalTestCase{`(json-stringify 'null)`, `"null"`},
+		evalTestCase{`(json-stringify '(("a" 1)))`, `"[[\"a\",1]]"`},
+		evalTestCase{`(json-stringify (json-parse "{\"z\":1,\"a\":[{\"k\":null},{},[]]}"))`, `"{\"z\":1,\"a\":[{\"k\":null},{},[]]}"`},
+		evalTestCase{`(try (json-stringify '(object 1)) error-message)`, `"json-stringify: Cannot convert to JSON: invalid object entry 1"`},
+		evalTestCase{`(try (json-stringify car) error-message)`, `"json-stringify: Cannot convert to JSON: <function car>"`},
+	} {
+		if expr := env.EvalString(tc.input); expr.String() != tc.output {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+		}
+	}
+}
+
+func TestJSONToExpr(t *testing.T) {
+	var v interface{}
+	json.Unmarshal([]byte(`{"z": [1, 0.5, 1e3, []], "a": "s", "n": null, "o": {}}`), &v)
+	expr, err := JSONToExpr(v)
+	if err != nil || expr.String() != `(object ("a" "s") ("n" null) ("o" (object)) ("z" (1 0.5 1000 ())))` {
+		t.Errorf("Received [[%v]], %v", expr, err)
+	}
+	back, err := ExprToJSON(expr)
+	if err != nil {
+		t.Errorf("ERROR: [[%v]]", err)
+	}
+	data, _ := json.Marshal(back)
+	if string(data) != `{"a":"s","n":null,"o":{},"z":[1,0.5,1000,[]]}` {
+		t.Errorf("Received [[%v]]", string(data))
+	}
+}
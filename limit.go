@@ -0,0 +1,242 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrBudgetExceeded is returned by EvalContext when an evaluation takes more
+// steps than it was given.
+var ErrBudgetExceeded = errors.New("Evaluation budget exceeded")
+
+// ErrMemoryLimitExceeded is raised when an evaluation allocates more than
+// the memory limit of its Env.  Unlike the other limits, it is a
+// *RuntimeError, so try can catch it; the data allocated by the body of
+// try is no longer counted then.
+var ErrMemoryLimitExceeded = NewRuntimeError("Memory limit exceeded")
+
+// Approximate sizes of the data made by the constructors.  An evaluation
+// is charged for the cells and strings it gets as the values of the
+// expressions it evaluates, unless another evaluation was charged for them
+// before.  Data that is only used while evaluating, such as argument
+// lists, is not counted.
+//
+// The constructors themselves cannot charge anything, as they do not know
+// which of the evaluations running at once they are called for.  Charging
+// the value of each builtin call instead counts the same data soon enough,
+// since no builtin makes more of it than the size of its arguments or of
+// what it reads: a loop building a list or a string is stopped at the
+// step that exceeds the limit.  The data that grows without being a value,
+// the buffers of channels and string output ports, is charged by the
+// builtins that make it grow, before it is allocated.
+const (
+	cellSize   = 32
+	stringSize = 16
+	// slotSize is the size of a place in the buffer of a channel.
+	slotSize = 16
+)
+
+// budget limits the evaluation in progress.  Running out of time or fuel
+// panics with a plain error rather than a *RuntimeError, so try cannot
+// catch it.
+type budget struct {
+	ctx context.Context
+	// fuel is the number of steps left, if limited is set.
+	fuel    int64
+	limited bool
+	// allocated is the number of bytes the evaluation was charged for, and
+	// memoryLimit the number it may allocate, or zero for no limit.
+	allocated   int64
+	memoryLimit int64
+	// parent is the budget of the evaluation this one is nested in.
+	parent *budget
+}
+
+func (b *budget) spend() {
+	select {
+	case <-b.ctx.Done():
+		panic(b.ctx.Err())
+	default:
+	}
+	if b.limited && atomic.AddInt64(&b.fuel, -1) < 0 {
+		panic(ErrBudgetExceeded)
+	}
+	if b.parent != nil {
+		b.parent.spend()
+	}
+}
+
+// charge charges the evaluation and those it is nested in for size more
+// bytes.
+func (b *budget) charge(size int64) {
+	exceeded := false
+	for p := b; p != nil; p = p.parent {
+		allocated := atomic.AddInt64(&p.allocated, size)
+		if 0 < p.memoryLimit && p.memoryLimit < allocated {
+			exceeded = true
+		}
+	}
+	if exceeded && 0 < size {
+		panic(ErrMemoryLimitExceeded)
+	}
+}
+
+// currentBudget returns the budget of the evaluation in env, or nil if it
+// has none.
+func (env *Env) currentBudget() *budget {
+	if env.dynamic == nil {
+		return nil
+	}
+	return env.dynamic.budget
+}
+
+// currentContext returns the context of the evaluation in env.
+func (env *Env) currentContext() context.Context {
+	if b := env.currentBudget(); b != nil {
+		return b.ctx
+	}
+	return context.Background()
+}
+
+// contextError returns the error raised when an evaluation waiting for
+// something finds that ctx is done.  Unlike ctx.Err() raised by the
+// evaluation itself, try can catch it.
+func contextError(ctx context.Context) *RuntimeError {
+	return NewRuntimeError(ctx.Err().Error())
+}
+
+// mark returns the number of bytes b was charged for so far.
+func (b *budget) mark() int64 {
+	if b == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&b.allocated)
+}
+
+// release takes back the charges made to b since mark, once the data they
+// were for is garbage.
+func (b *budget) release(mark int64) {
+	if b != nil {
+		b.charge(mark - b.mark())
+	}
+}
+
+// allocate charges the evaluation in env for size bytes about to be
+// allocated for it.
+func (env *Env) allocate(size int64) {
+	if b := env.currentBudget(); b != nil && 0 < size {
+		b.charge(size)
+	}
+}
+
+// account charges the evaluation in env for the data in value no
+// evaluation was charged for yet, and returns value.
+func (env *Env) account(value Expr) Expr {
+	if b := env.currentBudget(); b != nil {
+		if size := sizeOf(value); 0 < size {
+			b.charge(size)
+		}
+	}
+	return value
+}
+
+// sizeOf returns the size of the cells and strings in value that are not
+// accounted for yet, and marks them accounted.
+func sizeOf(value Expr) int64 {
+	size := int64(0)
+	switch v := value.(type) {
+	case *String:
+		if atomic.CompareAndSwapUint32(&v.accounted, 0, 1) {
+			size += stringSize + int64(len(v.value))
+		}
+	case *Cell:
+		for c := v; atomic.CompareAndSwapUint32(&c.accounted, 0, 1); c = c.cdr {
+			size += cellSize + sizeOf(c.car)
+		}
+	}
+	return size
+}
+
+// SetMemoryLimit limits each evaluation in env to allocating about limit
+// bytes of yall data.  Each call of Eval or EvalContext from Go is one
+// evaluation, and so is each form evaluated by EvalString or Load.  A limit
+// of zero or less means no limit.
+func (env *Env) SetMemoryLimit(limit int) {
+	atomic.StoreInt64(&env.root().memoryLimit, int64(limit))
+}
+
+func (env *Env) currentMemoryLimit() int64 {
+	return atomic.LoadInt64(&env.root().memoryLimit)
+}
+
+// limitMemory returns a frame of env that evaluates under the memory limit
+// of env, or nil if env has none.  It is used for evaluations that were
+// not given a budget by EvalContext.
+func (env *Env) limitMemory() *Env {
+	limit := env.currentMemoryLimit()
+	if limit <= 0 {
+		return nil
+	}
+	b := &budget{ctx: context.Background(), memoryLimit: limit}
+	return env.withDynamic(func(d *dynamic) {
+		d.budget = b
+	})
+}
+
+// EvalContext evaluates expr like Eval, but gives up when ctx is done or
+// after fuel steps, each evaluation of a subexpression being one step.  A
+// fuel of zero or less means no step limit.  The limits cover everything
+// evaluated for expr, including goroutines it starts with go, but not
+// other evaluations in env running at the same time.  Errors are returned
+// rather than panicking: ctx.Err() when ctx is done, ErrBudgetExceeded
+// when fuel runs out, ErrMemoryLimitExceeded when the memory limit set by
+// SetMemoryLimit is exceeded, and *RuntimeError or *SyntaxError for
+// uncaught yall errors.  Waiting on a channel when ctx is done raises a
+// yall error instead, which try can catch.  env can be used again
+// afterwards.
+func (env *Env) EvalContext(ctx context.Context, expr Expr, fuel int) (result Expr, err error) {
+	return env.evalContext(ctx, fuel, func(scoped *Env) Expr {
+		return scoped.Eval(expr)
+	})
+}
+
+// EvalStringContext evaluates every form in s under the limits of
+// EvalContext, and returns the value of the last one.
+func (env *Env) EvalStringContext(ctx context.Context, s string, fuel int) (result Expr, err error) {
+	return env.evalContext(ctx, fuel, func(scoped *Env) Expr {
+		return scoped.EvalReader(scoped.NewReader(strings.NewReader(s)))
+	})
+}
+
+func (env *Env) evalContext(ctx context.Context, fuel int, eval func(*Env) Expr) (result Expr, err error) {
+	parent := env.currentBudget()
+	b := &budget{
+		ctx:         ctx,
+		fuel:        int64(fuel),
+		limited:     0 < fuel,
+		memoryLimit: env.currentMemoryLimit(),
+		parent:      parent,
+	}
+	scoped := env.withDynamic(func(d *dynamic) {
+		d.budget = b
+	})
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(error)
+			if !ok {
+				panic(r)
+			}
+			result, err = nil, e
+		}
+	}()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return eval(scoped), nil
+}
@@ -0,0 +1,159 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEvalContextFuel(t *testing.T) {
+	env := NewEnv()
+	env.EvalString("(defn (forever n) (forever (+ n 1)))")
+	if _, err := env.EvalStringContext(context.Background(), "(forever 0)", 10000); err != ErrBudgetExceeded {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", err, ErrBudgetExceeded)
+	}
+	if _, err := env.EvalStringContext(context.Background(), "(try (forever 0) error-message)", 10000); err != ErrBudgetExceeded {
+		t.Errorf("try caught [[%v]]", err)
+	}
+	expr, err := env.EvalStringContext(context.Background(), "(map (fn (x) (* x x)) '(1 2 3))", 10000)
+	if err != nil || expr.String() != "(1 4 9)" {
+		t.Errorf("Received [[%v]] [[%v]] when expecting [[%v]]", expr, err, "(1 4 9)")
+	}
+	env.EvalString("(set-macro-character \"#forever\" (fn (port key) (forever 0)))")
+	if _, err := env.EvalStringContext(context.Background(), "#forever", 10000); err != ErrBudgetExceeded {
+		t.Errorf("Received [[%v]] from a reader macro when expecting [[%v]]", err, ErrBudgetExceeded)
+	}
+	if _, err := env.EvalStringContext(context.Background(), "(car 1)", 0); err == nil || err.Error() != "*** ERROR: pair required, but got 1" {
+		t.Errorf("Received [[%v]]", err)
+	}
+	if expr := env.EvalString("(+ 1 2)"); expr.String() != "3" {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", expr, "3")
+	}
+}
+
+func TestEvalContextCancel(t *testing.T) {
+	env := NewEnv()
+	ctx, cancel := context.WithCancel(context.Background())
+	env.Register("cancel", cancel)
+	env.EvalString("(defn (spin) (cancel) (spin))")
+	if _, err := env.EvalStringContext(ctx, "(spin)", 0); err != context.Canceled {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", err, context.Canceled)
+	}
+	if _, err := env.EvalStringContext(ctx, "1", 0); err != context.Canceled {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", err, context.Canceled)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	env.EvalString("(defn (wait n) (if (= n 0) 0 (wait (- n 1))))")
+	for i := 0; i < 1000; i++ {
+		if _, err := env.EvalStringContext(ctx, "(wait 100)", 0); err != nil {
+			if err != context.DeadlineExceeded {
+				t.Errorf("Received [[%v]] when expecting [[%v]]", err, context.DeadlineExceeded)
+			}
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Errorf("The deadline was not respected")
+}
+
+func TestOverlappingEvalContext(t *testing.T) {
+	env := NewEnv()
+	env.EvalString("(defn (wait n) (if (= n 0) 0 (wait (- n 1))))")
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(i%5)*time.Millisecond)
+			defer cancel()
+			env.EvalStringContext(ctx, "(wait 100000)", 0)
+		}(i)
+	}
+	wg.Wait()
+	expr, err := env.EvalStringContext(context.Background(), "(+ 1 2)", 0)
+	if err != nil || expr.String() != "3" {
+		t.Errorf("Received [[%v]] [[%v]] when expecting [[%v]]", expr, err, "3")
+	}
+	if expr := env.EvalString("(wait 1000)"); expr.String() != "0" {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", expr, "0")
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	env := NewEnv()
+	env.SetMemoryLimit(1 << 20)
+	env.EvalString("(defn (build n acc) (if (= n 0) acc (build (- n 1) (cons n acc))))")
+	env.EvalString("(defn (shout n) (if (= n 0) 0 (shout (- n (if (display \"" + strings.Repeat("x", 100) + "\") 1)))))")
+	if _, err := env.EvalStringContext(context.Background(), "(build 1000000 ())", 0); err != ErrMemoryLimitExceeded {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", err, ErrMemoryLimitExceeded)
+	}
+	expr, err := env.EvalStringContext(context.Background(), "(build 3 ())", 0)
+	if err != nil || expr.String() != "(1 2 3)" {
+		t.Errorf("Received [[%v]] [[%v]] when expecting [[%v]]", expr, err, "(1 2 3)")
+	}
+	for _, tc := range []evalTestCase{
+		evalTestCase{"(try (build 1000000 ()) error-message)", "\"Memory limit exceeded\""},
+		evalTestCase{"(cons (try (build 1000000 ()) error-message) (build 3 ()))", "(\"Memory limit exceeded\" 1 2 3)"},
+		evalTestCase{"(car (build 10000 ()))", "1"},
+		evalTestCase{"(try (make-chan 1000000) error-message)", "\"Memory limit exceeded\""},
+		evalTestCase{"(try (with-output-to-string (fn () (shout 20000))) error-message)", "\"Memory limit exceeded\""},
+		evalTestCase{"(with-output-to-string (fn () (shout 2)))", "\"" + strings.Repeat("x", 200) + "\""},
+	} {
+		if expr := env.EvalString(tc.input); expr.String() != tc.output {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+		}
+	}
+	env.SetMemoryLimit(0)
+	if _, err := env.EvalStringContext(context.Background(), "(build 10000 ())", 0); err != nil {
+		t.Errorf("Received [[%v]] without a memory limit", err)
+	}
+}
+
+func TestConcurrentMemoryLimit(t *testing.T) {
+	env := NewEnv()
+	env.SetMemoryLimit(1 << 19)
+	env.EvalString("(defn (build n acc) (if (= n 0) acc (build (- n 1) (cons n acc))))")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			expr, err := env.EvalStringContext(context.Background(), "(car (build 10000 ()))", 0)
+			if err != nil || expr.String() != "1" {
+				t.Errorf("Received [[%v]] [[%v]] when expecting [[%v]]", expr, err, "1")
+			}
+			if expr := env.EvalString("(car (build 10000 ()))"); expr.String() != "1" {
+				t.Errorf("Received [[%v]] when expecting [[%v]]", expr, "1")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestEvalContextChannels(t *testing.T) {
+	env := NewEnv()
+	for _, tc := range []evalTestCase{
+		evalTestCase{"(chan-recv (make-chan))", "*** ERROR: context deadline exceeded"},
+		evalTestCase{"(chan-send (make-chan) 1)", "*** ERROR: context deadline exceeded"},
+		evalTestCase{"(select (recv (make-chan) (x) x))", "*** ERROR: context deadline exceeded"},
+	} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		if _, err := env.EvalStringContext(ctx, tc.input, 0); err == nil || err.Error() != tc.output {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, err, tc.output)
+		}
+		cancel()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	expr, err := env.EvalStringContext(ctx, "(try (chan-recv (make-chan)) error-message)", 0)
+	if err != nil || expr.String() != "\"context deadline exceeded\"" {
+		t.Errorf("Received [[%v]] [[%v]] when expecting [[%v]]", expr, err, "\"context deadline exceeded\"")
+	}
+}
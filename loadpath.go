@@ -0,0 +1,209 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// AddLoadPath appends dir to the directories searched by load and require
+// for files that are not found next to the file loading them.  They are
+// searched before the directories in $YALL_PATH.
+func (env *Env) AddLoadPath(dir string) {
+	root := env.root()
+	root.lock.Lock()
+	defer root.lock.Unlock()
+	root.loadPaths = append(root.loadPaths, dir)
+}
+
+func (env *Env) searchPath() []string {
+	root := env.root()
+	root.lock.RLock()
+	dirs := append([]string{}, root.loadPaths...)
+	root.lock.RUnlock()
+	for _, dir := range filepath.SplitList(os.Getenv("YALL_PATH")) {
+		if dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+func (env *Env) isAbs(name string) bool {
+	if env.root().fsys != nil {
+		return path.IsAbs(name)
+	}
+	return filepath.IsAbs(name)
+}
+
+func (env *Env) join(dir string, name string) string {
+	if env.root().fsys != nil {
+		return path.Join(dir, name)
+	}
+	return filepath.Join(dir, name)
+}
+
+func (env *Env) fileExists(name string) bool {
+	root := env.root()
+	if root.fsys != nil {
+		_, err := fs.Stat(root.fsys, name)
+		return err == nil
+	}
+	if root.builtins&FSBuiltins == 0 {
+		return false
+	}
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// currentDir returns the directory of the file being loaded, or "." if
+// there is none.
+func (env *Env) currentDir() string {
+	if env.dynamic == nil || len(env.dynamic.loading) == 0 {
+		return "."
+	}
+	loading := env.dynamic.loading[len(env.dynamic.loading)-1]
+	if env.root().fsys != nil {
+		return path.Dir(loading)
+	}
+	return filepath.Dir(loading)
+}
+
+// resolve returns the path of the file name.  A relative name is looked up
+// in the directory of the file being loaded first, then in the load path.
+// If it is found nowhere, the path next to the loading file is returned so
+// that opening it reports the error.
+func (env *Env) resolve(name string) string {
+	if env.isAbs(name) {
+		return name
+	}
+	first := env.join(env.currentDir(), name)
+	if env.fileExists(first) {
+		return first
+	}
+	for _, dir := range env.searchPath() {
+		if candidate := env.join(dir, name); env.fileExists(candidate) {
+			return candidate
+		}
+	}
+	return first
+}
+
+// startLoading returns a frame of env in which the file at path is being
+// loaded.  It reports false if path has been loaded by require before and
+// once is set, and raises an error if path is already being loaded by the
+// same evaluation.  If once is set, finishLoading must be called when the
+// load is over.
+func (env *Env) startLoading(path string, once bool) (*Env, bool) {
+	var stack []string
+	if env.dynamic != nil {
+		stack = env.dynamic.loading
+	}
+	for i, loading := range stack {
+		if loading == path {
+			cycle := append(append([]string{}, stack[i:]...), path)
+			panic(NewRuntimeError("Cyclic load: " + strings.Join(cycle, " -> ")))
+		}
+	}
+	if once && !env.claim(path) {
+		return nil, false
+	}
+	return env.withDynamic(func(d *dynamic) {
+		d.loading = append(append([]string{}, stack...), path)
+	}), true
+}
+
+// claim makes the evaluation in env the one to require the file at path,
+// and reports false if it has been required before.  If another
+// evaluation is requiring it, claim waits for that to be over first.
+func (env *Env) claim(path string) bool {
+	root := env.root()
+	for {
+		root.lock.Lock()
+		if root.loaded[path] {
+			root.lock.Unlock()
+			return false
+		}
+		done, requiring := root.requiring[path]
+		if !requiring {
+			if root.requiring == nil {
+				root.requiring = make(map[string]chan struct{})
+			}
+			root.requiring[path] = make(chan struct{})
+			root.lock.Unlock()
+			return true
+		}
+		root.lock.Unlock()
+		ctx := env.currentContext()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			panic(contextError(ctx))
+		}
+	}
+}
+
+// finishLoading records that the file at path has been loaded if ok is
+// set.  If claimed is set, the evaluations waiting to require it go on.
+func (env *Env) finishLoading(path string, claimed bool, ok bool) {
+	root := env.root()
+	root.lock.Lock()
+	defer root.lock.Unlock()
+	if ok {
+		if root.loaded == nil {
+			root.loaded = make(map[string]bool)
+		}
+		root.loaded[path] = true
+	}
+	if claimed {
+		close(root.requiring[path])
+		delete(root.requiring, path)
+	}
+}
+
+// loadFile evaluates the file name in env, resolving it as resolve does.
+// If once is set, the file is skipped when it has been loaded before.
+func (env *Env) loadFile(name string, once bool) error {
+	resolved := env.resolve(name)
+	key := resolved
+	if env.root().fsys == nil {
+		if abs, err := filepath.Abs(resolved); err == nil {
+			key = abs
+		}
+	}
+	loading, ok := env.startLoading(key, once)
+	if !ok {
+		return nil
+	}
+	loaded := false
+	defer func() {
+		env.finishLoading(key, once, loaded)
+	}()
+	file, err := env.openFile(resolved)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if faslPath, compiled := env.faslPath(key); compiled {
+		if err := loading.loadCompiled(file, faslPath); err != nil {
+			return err
+		}
+	} else {
+		loading.Load(file)
+	}
+	loaded = true
+	return nil
+}
+
+// LoadFile evaluates the file name in env.  Files loaded by it can load
+// others relative to their own directory.  An error is returned if the
+// file cannot be opened; errors while evaluating it panic as in Load.
+func (env *Env) LoadFile(name string) error {
+	return env.loadFile(name, false)
+}
@@ -0,0 +1,144 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadPath(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"app/main.yall":   `(require "helper.yall" "helper.yall") (require "shared.yall")`,
+		"app/helper.yall": `(inc! helper-loads)`,
+		"lib/shared.yall": `(require "util.yall") (inc! shared-loads)`,
+		"env/util.yall":   `(def util-loaded #t)`,
+		"app/cycle.yall":  `(load "cycle2.yall")`,
+		"app/cycle2.yall": `(load "cycle.yall")`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0777)
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("YALL_PATH", filepath.Join(dir, "env"))
+	env := NewEnv()
+	env.AddLoadPath(filepath.Join(dir, "lib"))
+	env.EvalString("(def helper-loads 0) (def shared-loads 0)")
+	if err := env.LoadFile(filepath.Join(dir, "app", "main.yall")); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.LoadFile(filepath.Join(dir, "app", "main.yall")); err != nil {
+		t.Fatal(err)
+	}
+	cycle := filepath.Join(dir, "app", "cycle.yall")
+	cycle2 := filepath.Join(dir, "app", "cycle2.yall")
+	env.Intern(NewSymbol("cycle"), NewString(cycle))
+	for _, tc := range []evalTestCase{
+		evalTestCase{"helper-loads", "1"},
+		evalTestCase{"shared-loads", "1"},
+		evalTestCase{"util-loaded", "#t"},
+		evalTestCase{"(try (require \"helper.yall\") error-message)",
+			"\"Cannot require: \\\"helper.yall\\\": open helper.yall: no such file or directory\""},
+		evalTestCase{"(try (load cycle) error-message)",
+			"\"Cyclic load: " + cycle + " -> " + cycle2 + " -> " + cycle + "\""},
+		evalTestCase{"(try (load cycle) error-message)",
+			"\"Cyclic load: " + cycle + " -> " + cycle2 + " -> " + cycle + "\""},
+	} {
+		if expr := env.EvalString(tc.input); expr.String() != tc.output {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+		}
+	}
+}
+
+func TestOverlappingLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"wait.yall":  `(chan-send started 1) (chan-recv release) (load "count.yall")`,
+		"count.yall": `(inc! loads)`,
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	env := NewEnv()
+	env.EvalString("(def started (make-chan)) (def release (make-chan)) (def loads 0)")
+	env.Intern(NewSymbol("wait"), NewString(filepath.Join(dir, "wait.yall")))
+	load := func(result chan Expr) {
+		result <- env.EvalString("(try (if (load wait) loads) error-message)")
+	}
+	a, b := make(chan Expr), make(chan Expr)
+	go load(a)
+	env.EvalString("(chan-recv started)")
+	go load(b)
+	// The second load of the same file is not taken for a cycle.
+	started := make(chan Expr, 1)
+	go func() {
+		started <- env.EvalString("(chan-recv started)")
+	}()
+	select {
+	case expr := <-b:
+		t.Fatalf("Received [[%v]] while the first load was running", expr)
+	case <-started:
+	}
+	env.EvalString("(chan-send release 1)")
+	if expr := <-a; expr.String() != "1" {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", expr, "1")
+	}
+	env.EvalString("(chan-send release 1)")
+	if expr := <-b; expr.String() != "2" {
+		t.Errorf("Received [[%v]] when expecting [[%v]]", expr, "2")
+	}
+}
+
+func TestOverlappingRequire(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yall")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	source := `(chan-send started 1) (chan-recv release) (def required 1)`
+	if err := ioutil.WriteFile(filepath.Join(dir, "once.yall"), []byte(source), 0666); err != nil {
+		t.Fatal(err)
+	}
+	env := NewEnv()
+	env.EvalString("(def started (make-chan)) (def release (make-chan))")
+	env.Intern(NewSymbol("once"), NewString(filepath.Join(dir, "once.yall")))
+	require := func(result chan Expr) {
+		result <- env.EvalString("(try (if (require once) required) error-message)")
+	}
+	a, b := make(chan Expr), make(chan Expr)
+	go require(a)
+	env.EvalString("(chan-recv started)")
+	// The second require waits for the first rather than loading again.
+	go require(b)
+	time.Sleep(50 * time.Millisecond)
+	env.EvalString("(chan-send release 1)")
+	for _, result := range []chan Expr{a, b} {
+		select {
+		case expr := <-result:
+			if expr.String() != "1" {
+				t.Errorf("Received [[%v]] when expecting [[%v]]", expr, "1")
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("The file was required twice")
+		}
+	}
+}
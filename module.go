@@ -0,0 +1,232 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Module is a named set of exported bindings.  Its exports do not change
+// once it is defined.
+type Module struct {
+	name    string
+	exports map[string]Expr
+	// env is the global environment a registered module is made for.
+	env *Env
+}
+
+func newModule(name string) *Module {
+	return &Module{name: name, exports: make(map[string]Expr)}
+}
+
+func (module *Module) Name() string {
+	return module.name
+}
+
+func (module *Module) lookup(name string) (Expr, bool) {
+	value, ok := module.exports[name]
+	return value, ok
+}
+
+// Define exports value from module as name.
+func (module *Module) Define(name string, value Expr) {
+	module.exports[name] = value
+}
+
+// Register exports fn, which must be a Go function, from module as the
+// yall function name, converting its arguments and results as
+// Env.Register does.
+func (module *Module) Register(name string, fn interface{}) {
+	f := reflect.ValueOf(fn)
+	if f.Kind() != reflect.Func || f.IsNil() {
+		panic(NewRuntimeError("Can't register " + name + ": not a function"))
+	}
+	key := module.name + "/" + name
+	function := newClosureFunction(name, &closure{env: module.env, builtin: wrapGoFunction(key, f)})
+	function.key = key
+	module.Define(name, function)
+}
+
+type nativeModule struct {
+	builtins Builtins
+	init     func(*Module)
+}
+
+var nativeModules struct {
+	sync.RWMutex
+	modules map[string]nativeModule
+}
+
+// RegisterModule makes the module name available to the Envs that have
+// ModuleBuiltins installed, such as those made by NewEnv.  The first time
+// a script in an Env imports or refers to it, init is called to define its
+// exports for that Env.  Go packages providing modules usually call
+// RegisterModule from their init function, so that importing the package
+// is enough.
+func RegisterModule(name string, init func(*Module)) {
+	RegisterModuleIn(name, 0, init)
+}
+
+// RegisterModuleIn is like RegisterModule, but the module is only
+// available to the Envs that have builtins installed as well, for modules
+// that give the same access as those builtins.
+func RegisterModuleIn(name string, builtins Builtins, init func(*Module)) {
+	nativeModules.Lock()
+	defer nativeModules.Unlock()
+	if nativeModules.modules == nil {
+		nativeModules.modules = make(map[string]nativeModule)
+	}
+	nativeModules.modules[name] = nativeModule{builtins | ModuleBuiltins, init}
+}
+
+// names returns the names exported by module in sorted order.
+func (module *Module) names() []string {
+	names := make([]string, 0, len(module.exports))
+	for name := range module.exports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// module returns the module name, if it has been defined or registered by
+// RegisterModule.
+func (env *Env) module(name string) (*Module, bool) {
+	root := env.root()
+	if module, ok := root.definedModule(name); ok {
+		return module, true
+	}
+	nativeModules.RLock()
+	native, ok := nativeModules.modules[name]
+	nativeModules.RUnlock()
+	if !ok || root.builtins&native.builtins != native.builtins {
+		return nil, false
+	}
+	// The module is made by one goroutine, so that init is called once.
+	root.moduleLock.Lock()
+	defer root.moduleLock.Unlock()
+	if module, ok := root.definedModule(name); ok {
+		return module, true
+	}
+	module := newModule(name)
+	module.env = root
+	native.init(module)
+	root.defineModule(module)
+	return module, true
+}
+
+func (env *Env) definedModule(name string) (*Module, bool) {
+	root := env.root()
+	root.lock.RLock()
+	defer root.lock.RUnlock()
+	module, ok := root.modules[name]
+	return module, ok
+}
+
+func (env *Env) defineModule(module *Module) {
+	root := env.root()
+	root.lock.Lock()
+	defer root.lock.Unlock()
+	if root.modules == nil {
+		root.modules = make(map[string]*Module)
+	}
+	root.modules[module.name] = module
+}
+
+// findModule returns the module name.  A module that has not been defined
+// yet is required from the file name.yall, which is expected to define it.
+func (env *Env) findModule(name string) *Module {
+	if module, ok := env.module(name); ok {
+		return module
+	}
+	if err := env.global().Derive().loadFile(name+".yall", true); err != nil {
+		panic(NewRuntimeError("Unknown module: " + name + ": " + err.Error()))
+	}
+	if module, ok := env.module(name); ok {
+		return module
+	}
+	panic(NewRuntimeError("Unknown module: " + name + ": not defined by " + name + ".yall"))
+}
+
+// lookupQualified returns the value of a qualified reference such as
+// str/join, which names the export join of the module str.
+func (env *Env) lookupQualified(name string) (Expr, bool) {
+	i := strings.LastIndex(name, "/")
+	if i <= 0 || i == len(name)-1 {
+		return nil, false
+	}
+	module, ok := env.module(name[:i])
+	if !ok {
+		return nil, false
+	}
+	return module.lookup(name[i+1:])
+}
+
+// internImport binds name to value imported from a module.  Importing the
+// same value again is allowed, but other bindings are never overwritten.
+func (env *Env) internImport(name string, value Expr) {
+	env = env.lexical()
+	if old, found := env.lookup(name); found && old != nil && old != value {
+		panic(NewRuntimeError("Can't overwrite " + name))
+	}
+	env.lock.Lock()
+	defer env.lock.Unlock()
+	env.values[name] = value
+}
+
+func symbolArg(name string, expr Expr) *Symbol {
+	if symbol, ok := expr.(*Symbol); ok {
+		return symbol
+	}
+	panic(NewRuntimeError(name + " requires a symbol, but got " + printable(expr)))
+}
+
+var moduleSpecialForms = map[string]func(*Env, *Cell) Expr{
+
+	// (module name (export symbol...) body...) evaluates body in a new
+	// frame of the global environment, and defines the module name
+	// exporting the values of the symbols.
+	"module": func(env *Env, args *Cell) Expr {
+		symbol := symbolArg("module", args.Car())
+		exports, ok := args.Cadr().(*Cell)
+		if !ok || Empty == exports || symbolArg("module", exports.Car()).Name() != "export" {
+			panic(NewRuntimeError("module requires (export symbol...) after the name"))
+		}
+		derived := env.global().Derive()
+		derived.Begin(args.Cdr().Cdr())
+		module := newModule(symbol.Name())
+		exports.Cdr().Each(func(expr Expr) {
+			export := symbolArg("export", expr)
+			module.exports[export.Name()] = derived.EvalSymbol(export)
+		})
+		env.defineModule(module)
+		return symbol
+	},
+
+	// (import spec...) binds the exports of modules.  A spec is either the
+	// name of a module or (prefix name p), which binds them with the names
+	// prefixed by p.
+	"import": func(env *Env, args *Cell) Expr {
+		args.Each(func(spec Expr) {
+			prefix := ""
+			if cell, ok := spec.(*Cell); ok {
+				if Empty == cell || symbolArg("import", cell.Car()).Name() != "prefix" {
+					panic(NewRuntimeError("import requires a module name or (prefix name p)"))
+				}
+				spec = cell.Cadr()
+				prefix = symbolArg("import", cell.Caddr()).Name()
+			}
+			module := env.findModule(symbolArg("import", spec).Name())
+			for _, name := range module.names() {
+				value, _ := module.lookup(name)
+				env.internImport(prefix+name, value)
+			}
+		})
+		return True
+	},
+}
@@ -0,0 +1,144 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/fstest"
+)
+
+func TestModule(t *testing.T) {
+	env := NewEnv()
+	env.EvalString(`
+(module counter (export next reset)
+  (def count 0)
+  (defn (next) (inc! count))
+  (defn (reset) (set! count 0)))`)
+	for _, tc := range []evalTestCase{
+		evalTestCase{"(counter/next)", "1"},
+		evalTestCase{"(counter/next)", "2"},
+		evalTestCase{"(try count error-message)", "\"Unbound variable: count\""},
+		evalTestCase{"(try (counter/count) error-message)", "\"Unbound variable: counter/count\""},
+		evalTestCase{"(try next error-message)", "\"Unbound variable: next\""},
+		evalTestCase{"(import counter)", "#t"},
+		evalTestCase{"(next)", "3"},
+		evalTestCase{"(import counter)", "#t"},
+		evalTestCase{"(import (prefix counter c:))", "#t"},
+		evalTestCase{"(c:reset)", "0"},
+		evalTestCase{"(next)", "1"},
+		evalTestCase{"((fn () (import (prefix counter local-)) (local-next)))", "2"},
+		evalTestCase{"(try local-next error-message)", "\"Unbound variable: local-next\""},
+		evalTestCase{"(/ 6 3)", "2"},
+		evalTestCase{"(def x-reset 1)", "x-reset"},
+		evalTestCase{"(try (import (prefix counter x-)) error-message)", "\"Can't overwrite x-reset\""},
+		evalTestCase{"(try (module m (next)) error-message)",
+			"\"module requires (export symbol...) after the name\""},
+	} {
+		if expr := env.EvalString(tc.input); expr.String() != tc.output {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+		}
+	}
+}
+
+func TestModuleFile(t *testing.T) {
+	loads := 0
+	fsys := fstest.MapFS{
+		"str.yall": &fstest.MapFile{Data: []byte(`
+(note-load)
+(module str (export twice)
+  (defn (twice s) (list s s)))`)},
+		"nothing.yall": &fstest.MapFile{Data: []byte("(def x 1)")},
+	}
+	env := NewSandboxEnv(SandboxOptions{Builtins: PureBuiltins, FS: fsys})
+	env.Register("note-load", func() { loads++ })
+	for _, tc := range []evalTestCase{
+		evalTestCase{"(import str)", "#t"},
+		evalTestCase{"(twice 1)", "(1 1)"},
+		evalTestCase{"(import (prefix str s-))", "#t"},
+		evalTestCase{"(s-twice 2)", "(2 2)"},
+		evalTestCase{"(str/twice 3)", "(3 3)"},
+		evalTestCase{"(try (import missing) error-message)",
+			"\"Unknown module: missing: open missing.yall: file does not exist\""},
+		evalTestCase{"(try (import nothing) error-message)",
+			"\"Unknown module: nothing: not defined by nothing.yall\""},
+		evalTestCase{"(try x error-message)", "\"Unbound variable: x\""},
+	} {
+		if expr := env.EvalString(tc.input); expr.String() != tc.output {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("str.yall was loaded %d times", loads)
+	}
+	pure := NewSandboxEnv(SandboxOptions{Builtins: PureBuiltins})
+	if expr := pure.EvalString("(try (import str) error-message)"); expr.String() !=
+		"\"Unknown module: str: open str.yall: permission denied\"" {
+		t.Errorf("Received [[%v]] importing without a file system", expr)
+	}
+}
+
+func TestRegisterModule(t *testing.T) {
+	inits := int32(0)
+	RegisterModule("test-native", func(m *Module) {
+		atomic.AddInt32(&inits, 1)
+		m.Define("answer", NewInteger(42))
+		m.Register("add", func(a int, b int) int { return a + b })
+	})
+	RegisterModuleIn("test-os", OSBuiltins, func(m *Module) {
+		m.Define("answer", NewInteger(42))
+	})
+	env := NewEnv()
+	for _, tc := range []evalTestCase{
+		evalTestCase{"(try answer error-message)", "\"Unbound variable: answer\""},
+		evalTestCase{"(test-native/add 1 2)", "3"},
+		evalTestCase{"(import test-native)", "#t"},
+		evalTestCase{"(add answer 1)", "43"},
+		evalTestCase{"(try (add 1) error-message)", "\"Too few arguments to test-native/add, exactly 2 required\""},
+		evalTestCase{"test-os/answer", "42"},
+	} {
+		if expr := env.EvalString(tc.input); expr.String() != tc.output {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+		}
+	}
+	if inits != 1 {
+		t.Errorf("The module was initialized %d times", inits)
+	}
+	// Registered modules are only available in sandboxes that allow them.
+	for _, tc := range []struct {
+		builtins Builtins
+		input    string
+		output   string
+	}{
+		{PureBuiltins, "(try test-native/answer error-message)", "\"Unbound variable: test-native/answer\""},
+		{PureBuiltins | ModuleBuiltins, "test-native/answer", "42"},
+		{PureBuiltins | ModuleBuiltins, "(try test-os/answer error-message)", "\"Unbound variable: test-os/answer\""},
+		{PureBuiltins | OSBuiltins | ModuleBuiltins, "test-os/answer", "42"},
+	} {
+		sandbox := NewSandboxEnv(SandboxOptions{Builtins: tc.builtins})
+		if expr := sandbox.EvalString(tc.input); expr.String() != tc.output {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+		}
+	}
+	// Each Env initializes the module once, even when first used by
+	// several goroutines at once.
+	inits = 0
+	env = NewEnv()
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if expr := env.EvalString("(test-native/add 1 2)"); expr.String() != "3" {
+				t.Errorf("Received [[%v]] when expecting [[%v]]", expr, "3")
+			}
+		}()
+	}
+	wg.Wait()
+	if inits != 1 {
+		t.Errorf("The module was initialized %d times", inits)
+	}
+}
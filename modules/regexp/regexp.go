@@ -0,0 +1,107 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package regexp provides the yall module regexp, with functions that take
+// the pattern in the syntax of the Go regexp package as their first
+// argument:
+//
+//	(import regexp)
+//	(match? "^a+$" "aaa")             ; #t
+//	(find "[0-9]+" "abc123def456")    ; "123", or #f if there is no match
+//	(find-all "[0-9]+" "a1b22")       ; ("1" "22")
+//	(submatches "(a)(b)?" "ab")       ; ("ab" "a" "b")
+//	(replace "a(x*)b" "-ab-" "${1}W") ; "-W-"
+//	(split ",\\s*" "a, b,c")          ; ("a" "b" "c")
+//
+// Importing this package registers the module.
+package regexp
+
+import (
+	goregexp "regexp"
+	"sync"
+
+	"github.com/yaraki/yall"
+)
+
+func init() {
+	yall.RegisterModule("regexp", define)
+}
+
+// maxCached is the number of compiled patterns kept by compile.
+const maxCached = 256
+
+var cache struct {
+	sync.Mutex
+	patterns map[string]*goregexp.Regexp
+}
+
+// compile returns the compiled pattern, which is kept so that calls with
+// the same pattern do not compile it again.
+func compile(pattern string) (*goregexp.Regexp, error) {
+	cache.Lock()
+	re, ok := cache.patterns[pattern]
+	cache.Unlock()
+	if ok {
+		return re, nil
+	}
+	re, err := goregexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	cache.Lock()
+	defer cache.Unlock()
+	if cache.patterns == nil || maxCached <= len(cache.patterns) {
+		cache.patterns = make(map[string]*goregexp.Regexp)
+	}
+	cache.patterns[pattern] = re
+	return re, nil
+}
+
+func define(m *yall.Module) {
+	m.Register("match?", func(pattern string, s string) (bool, error) {
+		re, err := compile(pattern)
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(s), nil
+	})
+	m.Register("find", func(pattern string, s string) (yall.Expr, error) {
+		re, err := compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if loc := re.FindStringIndex(s); loc != nil {
+			return yall.NewString(s[loc[0]:loc[1]]), nil
+		}
+		return yall.False, nil
+	})
+	m.Register("find-all", func(pattern string, s string) ([]string, error) {
+		re, err := compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return re.FindAllString(s, -1), nil
+	})
+	m.Register("submatches", func(pattern string, s string) ([]string, error) {
+		re, err := compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return re.FindStringSubmatch(s), nil
+	})
+	m.Register("replace", func(pattern string, s string, replacement string) (string, error) {
+		re, err := compile(pattern)
+		if err != nil {
+			return "", err
+		}
+		return re.ReplaceAllString(s, replacement), nil
+	})
+	m.Register("split", func(pattern string, s string) ([]string, error) {
+		re, err := compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		return re.Split(s, -1), nil
+	})
+}
@@ -0,0 +1,33 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package regexp
+
+import (
+	"testing"
+
+	"github.com/yaraki/yall"
+)
+
+func TestRegexp(t *testing.T) {
+	env := yall.NewEnv()
+	for _, tc := range []struct{ input, output string }{
+		{"(try (match? \"a\" \"a\") error-message)", "\"Unbound variable: match?\""},
+		{"(regexp/match? \"^a+$\" \"aaa\")", "#t"},
+		{"(import regexp)", "#t"},
+		{"(match? \"^a+$\" \"aab\")", "#f"},
+		{"(find \"[0-9]+\" \"abc123def456\")", "\"123\""},
+		{"(find \"[0-9]+\" \"abc\")", "#f"},
+		{"(find-all \"[0-9]+\" \"a1b22\")", "(\"1\" \"22\")"},
+		{"(submatches \"(a)(b)?\" \"ab\")", "(\"ab\" \"a\" \"b\")"},
+		{"(replace \"a(x*)b\" \"-ab-axxb-\" \"${1}W\")", "\"-W-xxW-\""},
+		{"(split \",\\\\s*\" \"a, b,c\")", "(\"a\" \"b\" \"c\")"},
+		{"(try (match? \"(\" \"\") error-message)",
+			"\"regexp/match?: error parsing regexp: missing closing ): `(`\""},
+	} {
+		if expr := env.EvalString(tc.input); expr.String() != tc.output {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+		}
+	}
+}
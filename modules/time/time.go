@@ -0,0 +1,59 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package time provides the yall module time.  Times are integers counting
+// the seconds since the Unix epoch:
+//
+//	(import time)
+//	(now)                                       ; the current time
+//	(format-time 0 "2006-01-02T15:04:05Z07:00") ; "1970-01-01T00:00:00Z"
+//	(parse-time "2006-01-02" "2012-12-21")      ; 1356048000
+//	(add-date 0 1 2 3)                          ; adds years, months and days
+//	(sleep 0.5)                                 ; sleeps for seconds
+//
+// Layouts are those of the Go time package, and times are formatted in
+// UTC.  Sleeping stops when the evaluation is cancelled.  Importing this
+// package registers the module, which is available in the Envs that have
+// yall.OSBuiltins installed.
+package time
+
+import (
+	"context"
+	gotime "time"
+
+	"github.com/yaraki/yall"
+)
+
+func init() {
+	yall.RegisterModuleIn("time", yall.OSBuiltins, define)
+}
+
+func define(m *yall.Module) {
+	m.Register("now", func() int64 {
+		return gotime.Now().Unix()
+	})
+	m.Register("format-time", func(seconds int64, layout string) string {
+		return gotime.Unix(seconds, 0).UTC().Format(layout)
+	})
+	m.Register("parse-time", func(layout string, s string) (int64, error) {
+		t, err := gotime.Parse(layout, s)
+		if err != nil {
+			return 0, err
+		}
+		return t.Unix(), nil
+	})
+	m.Register("add-date", func(seconds int64, years int, months int, days int) int64 {
+		return gotime.Unix(seconds, 0).UTC().AddDate(years, months, days).Unix()
+	})
+	m.Register("sleep", func(ctx context.Context, seconds float64) error {
+		timer := gotime.NewTimer(gotime.Duration(seconds * float64(gotime.Second)))
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+}
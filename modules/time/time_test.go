@@ -0,0 +1,51 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package time
+
+import (
+	"context"
+	"testing"
+	gotime "time"
+
+	"github.com/yaraki/yall"
+)
+
+func TestTime(t *testing.T) {
+	env := yall.NewEnv()
+	env.EvalString("(import time)")
+	for _, tc := range []struct{ input, output string }{
+		{"(format-time 0 \"2006-01-02T15:04:05Z07:00\")", "\"1970-01-01T00:00:00Z\""},
+		{"(parse-time \"2006-01-02\" \"2012-12-21\")", "1356048000"},
+		{"(format-time (add-date 1356048000 1 2 3) \"2006-01-02\")", "\"2014-02-24\""},
+		{"(= (now) 0)", "#f"},
+		{"(sleep 0.001)", "#t"},
+		{"(try (parse-time \"2006\" \"x\") error-message)",
+			"\"time/parse-time: parsing time \\\"x\\\" as \\\"2006\\\": cannot parse \\\"x\\\" as \\\"2006\\\"\""},
+	} {
+		if expr := env.EvalString(tc.input); expr.String() != tc.output {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+		}
+	}
+}
+
+func TestSleepCancel(t *testing.T) {
+	env := yall.NewEnv()
+	for _, input := range []string{"(time/sleep 5)", "(try (time/sleep 5) error-message)"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*gotime.Millisecond)
+		start := gotime.Now()
+		if _, err := env.EvalStringContext(ctx, input, 100); err != context.DeadlineExceeded {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", input, err, context.DeadlineExceeded)
+		}
+		if elapsed := gotime.Since(start); gotime.Second < elapsed {
+			t.Errorf("%v: Slept for %v after the deadline", input, elapsed)
+		}
+		cancel()
+	}
+	sandbox := yall.NewSandboxEnv(yall.SandboxOptions{})
+	if _, err := sandbox.EvalStringContext(context.Background(), "time/sleep", 0); err == nil ||
+		err.Error() != "*** ERROR: Unbound variable: time/sleep" {
+		t.Errorf("Received [[%v]] in a sandbox", err)
+	}
+}
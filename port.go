@@ -0,0 +1,305 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+)
+
+type InputPort struct {
+	r        *reader
+	consumed int
+	closer   io.Closer
+}
+
+func newInputPort(r *reader) *InputPort {
+	return &InputPort{r, 0, nil}
+}
+
+func NewInputPort(input io.Reader) *InputPort {
+	return newInputPort(&reader{input: bufio.NewReader(input)})
+}
+
+func (port *InputPort) String() string {
+	return "<input-port>"
+}
+
+// Close closes the underlying file of port, if any.
+func (port *InputPort) Close() error {
+	if port.closer == nil {
+		return nil
+	}
+	closer := port.closer
+	port.closer = nil
+	return closer.Close()
+}
+
+func (port *InputPort) ReadChar() Expr {
+	rune, size, err := port.r.input.ReadRune()
+	if err != nil {
+		return EOF
+	}
+	port.consumed += size
+	return NewString(string(rune))
+}
+
+func (port *InputPort) PeekChar() Expr {
+	rune, _, err := port.r.input.ReadRune()
+	if err != nil {
+		return EOF
+	}
+	port.r.input.UnreadRune()
+	return NewString(string(rune))
+}
+
+func (port *InputPort) ReadLine() Expr {
+	line, err := port.r.input.ReadString('\n')
+	port.consumed += len(line)
+	if err != nil && line == "" {
+		return EOF
+	}
+	return NewString(strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"))
+}
+
+func (port *InputPort) Read() Expr {
+	expr, size, err := port.r.readTokens(false)
+	port.consumed += size
+	if err == io.EOF {
+		return EOF
+	}
+	if err != nil {
+		panic(err)
+	}
+	return expr
+}
+
+type eofObject struct{}
+
+// EOF is returned by the port primitives when there is nothing left to read.
+var EOF *eofObject = &eofObject{}
+
+func (eof *eofObject) String() string {
+	return "#<eof>"
+}
+
+// OutputPort can be written to from several goroutines at once.
+type OutputPort struct {
+	lock   sync.Mutex
+	w      io.Writer
+	closer io.Closer
+}
+
+func NewOutputPort(w io.Writer) *OutputPort {
+	return &OutputPort{w: w}
+}
+
+func (port *OutputPort) String() string {
+	return "<output-port>"
+}
+
+// Close closes the underlying file of port, if any.
+func (port *OutputPort) Close() error {
+	port.lock.Lock()
+	defer port.lock.Unlock()
+	if port.closer == nil {
+		return nil
+	}
+	closer := port.closer
+	port.w, port.closer = closedWriter{}, nil
+	return closer.Close()
+}
+
+type closedWriter struct{}
+
+func (w closedWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write to a closed port")
+}
+
+func (port *OutputPort) WriteString(s string) {
+	port.lock.Lock()
+	defer port.lock.Unlock()
+	if _, err := io.WriteString(port.w, s); err != nil {
+		panic(NewRuntimeError("Failed to write: " + err.Error()))
+	}
+}
+
+// writeError writes err to port, for errors that have nowhere else to go.
+// Failing to write it is ignored.
+func (port *OutputPort) writeError(err error) {
+	port.lock.Lock()
+	defer port.lock.Unlock()
+	io.WriteString(port.w, err.Error()+"\n")
+}
+
+// write writes s to port for the evaluation in env, which is charged for
+// it if port writes to a string.
+func (port *OutputPort) write(env *Env, s string) {
+	port.lock.Lock()
+	_, buffered := port.w.(*bytes.Buffer)
+	port.lock.Unlock()
+	if buffered {
+		env.allocate(int64(len(s)))
+	}
+	port.WriteString(s)
+}
+
+// contents returns what has been written to port, if it writes to a string.
+func (port *OutputPort) contents() (string, bool) {
+	port.lock.Lock()
+	defer port.lock.Unlock()
+	if buffer, ok := port.w.(*bytes.Buffer); ok {
+		return buffer.String(), true
+	}
+	return "", false
+}
+
+// inputPortArg returns the port given as the first of args, or the current
+// input port of env if args is empty.
+func inputPortArg(env *Env, name string, args *Cell) *InputPort {
+	if Empty == args {
+		return env.currentInput()
+	}
+	if port, ok := args.Car().(*InputPort); ok {
+		return port
+	}
+	panic(NewRuntimeError(name + " requires an input port"))
+}
+
+// outputPortArg returns the port given as the first of args, or the current
+// output port of env if args is empty.
+func outputPortArg(env *Env, name string, args *Cell) *OutputPort {
+	if Empty == args {
+		return env.currentOutput()
+	}
+	if port, ok := args.Car().(*OutputPort); ok {
+		return port
+	}
+	panic(NewRuntimeError(name + " requires an output port"))
+}
+
+// ioFunctions are the builtin functions that read from or write to the
+// current ports of the environment they are interned in.
+var ioFunctions = map[string]func(*Env, *Cell) Expr{
+
+	"current-input-port": func(env *Env, args *Cell) Expr {
+		return env.currentInput()
+	},
+
+	"current-output-port": func(env *Env, args *Cell) Expr {
+		return env.currentOutput()
+	},
+
+	"open-input-string": func(env *Env, args *Cell) Expr {
+		if s, ok := args.Car().(*String); ok {
+			return newInputPort(env.NewReader(strings.NewReader(s.value)).r)
+		}
+		panic(NewRuntimeError("open-input-string requires a string"))
+	},
+
+	"open-output-string": func(env *Env, args *Cell) Expr {
+		return NewOutputPort(new(bytes.Buffer))
+	},
+
+	"get-output-string": func(env *Env, args *Cell) Expr {
+		if port, ok := args.Car().(*OutputPort); ok {
+			if s, ok := port.contents(); ok {
+				return NewString(s)
+			}
+		}
+		panic(NewRuntimeError("get-output-string requires a string output port"))
+	},
+
+	"with-output-to-string": func(env *Env, args *Cell) Expr {
+		thunk := functionArg("with-output-to-string", args.Car())
+		port := NewOutputPort(new(bytes.Buffer))
+		thunk.call(env.withDynamic(func(d *dynamic) {
+			d.output = port
+		}), Empty)
+		s, _ := port.contents()
+		return NewString(s)
+	},
+
+	"read-char": func(env *Env, args *Cell) Expr {
+		return inputPortArg(env, "read-char", args).ReadChar()
+	},
+
+	"peek-char": func(env *Env, args *Cell) Expr {
+		return inputPortArg(env, "peek-char", args).PeekChar()
+	},
+
+	"read-line": func(env *Env, args *Cell) Expr {
+		return inputPortArg(env, "read-line", args).ReadLine()
+	},
+
+	"read": func(env *Env, args *Cell) Expr {
+		return inputPortArg(env, "read", args).Read()
+	},
+
+	"println": func(env *Env, args *Cell) Expr {
+		port := env.currentOutput()
+		args.Each(func(expr Expr) {
+			port.write(env, Display(expr)+"\n")
+		})
+		return True
+	},
+
+	"write": func(env *Env, args *Cell) Expr {
+		outputPortArg(env, "write", args.Cdr()).write(env, args.Car().String())
+		return True
+	},
+
+	"display": func(env *Env, args *Cell) Expr {
+		outputPortArg(env, "display", args.Cdr()).write(env, Display(args.Car()))
+		return True
+	},
+
+	"newline": func(env *Env, args *Cell) Expr {
+		outputPortArg(env, "newline", args).write(env, "\n")
+		return True
+	},
+
+	// (format destination control args...) formats args as directed by
+	// control.  The result is returned as a string if destination is #f or
+	// (), written to the current output port if it is #t, and written to
+	// destination if it is a port.
+	"format": func(env *Env, args *Cell) Expr {
+		s := formatString(stringArg("format", args.Cadr()), args.Cdr().Cdr())
+		switch destination := args.Car().(type) {
+		case *OutputPort:
+			destination.write(env, s)
+		case *Bool:
+			if destination == False {
+				return NewString(s)
+			}
+			env.currentOutput().write(env, s)
+		case *Cell:
+			if destination != Empty {
+				panic(NewRuntimeError("format: invalid destination " + destination.String()))
+			}
+			return NewString(s)
+		default:
+			panic(NewRuntimeError("format: invalid destination " + printable(args.Car())))
+		}
+		return True
+	},
+
+	"pp": func(env *Env, args *Cell) Expr {
+		width := DefaultWidth
+		if Empty != args.Cdr() {
+			if i, ok := args.Cadr().(*Integer); ok {
+				width = i.Value()
+			} else {
+				panic(NewRuntimeError("pp requires an integer width"))
+			}
+		}
+		env.currentOutput().write(env, Pretty(args.Car(), width)+"\n")
+		return True
+	},
+}
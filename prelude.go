@@ -0,0 +1,79 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"embed"
+	"io/fs"
+	"os"
+)
+
+// lispFS holds the standard library, so that it is available wherever the
+// binary is installed.
+//
+//go:embed lisp/*.yall
+var lispFS embed.FS
+
+// preludeFile is the file of the standard library loaded into every Env.
+const preludeFile = "lisp/sys.yall"
+
+func (env *Env) loadPrelude() {
+	f, err := lispFS.Open(preludeFile)
+	if err != nil {
+		panic(NewRuntimeError("Failed to open " + preludeFile + ": " + err.Error()))
+	}
+	defer f.Close()
+	env.Load(f)
+}
+
+// EnvOptions configures NewEnvWithOptions.
+type EnvOptions struct {
+	// NoPrelude skips loading the standard library.
+	NoPrelude bool
+	// Prelude names files to load after the standard library, in order.
+	Prelude []string
+	// PreludeFS is the file system Prelude is read from.  If it is nil,
+	// the files are read from the host file system.
+	PreludeFS fs.FS
+}
+
+// NewEnvWithOptions returns a new Env with every builtin installed, and
+// the standard library and prelude files chosen by options loaded.
+func NewEnvWithOptions(options EnvOptions) (env *Env, err error) {
+	env = newBuiltinEnv(SandboxOptions{Builtins: AllBuiltins})
+	if !options.NoPrelude {
+		env.loadPrelude()
+	}
+	for _, name := range options.Prelude {
+		if err := env.loadPreludeFile(options.PreludeFS, name); err != nil {
+			return nil, err
+		}
+	}
+	return env, nil
+}
+
+func (env *Env) loadPreludeFile(fsys fs.FS, name string) (err error) {
+	var f fs.File
+	if fsys == nil {
+		f, err = os.Open(name)
+	} else {
+		f, err = fsys.Open(name)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(error)
+			if !ok {
+				panic(r)
+			}
+			err = e
+		}
+	}()
+	env.Load(f)
+	return nil
+}
@@ -0,0 +1,46 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestEnvWithOptions(t *testing.T) {
+	fsys := fstest.MapFS{
+		"rules.yall":  &fstest.MapFile{Data: []byte("(defn (double x) (* x 2))")},
+		"more.yall":   &fstest.MapFile{Data: []byte("(defn (quadruple x) (double (double x)))")},
+		"broken.yall": &fstest.MapFile{Data: []byte("(car 1)")},
+	}
+	env, err := NewEnvWithOptions(EnvOptions{Prelude: []string{"rules.yall", "more.yall"}, PreludeFS: fsys})
+	if err != nil {
+		t.Fatalf("Received [[%v]]", err)
+	}
+	for _, tc := range []evalTestCase{
+		evalTestCase{"(quadruple 3)", "12"},
+		evalTestCase{"(map double '(1 2))", "(2 4)"},
+	} {
+		if expr := env.EvalString(tc.input); expr.String() != tc.output {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+		}
+	}
+
+	env, err = NewEnvWithOptions(EnvOptions{NoPrelude: true})
+	if err != nil {
+		t.Fatalf("Received [[%v]]", err)
+	}
+	if expr := env.EvalString("(try map error-message)"); expr.String() != "\"Unbound variable: map\"" {
+		t.Errorf("Received [[%v]] without the prelude", expr)
+	}
+
+	if _, err = NewEnvWithOptions(EnvOptions{Prelude: []string{"missing.yall"}, PreludeFS: fsys}); err == nil {
+		t.Errorf("A missing prelude file was not reported")
+	}
+	_, err = NewEnvWithOptions(EnvOptions{Prelude: []string{"broken.yall"}, PreludeFS: fsys})
+	if err == nil || err.Error() != "*** ERROR: pair required, but got 1" {
+		t.Errorf("Received [[%v]] for a broken prelude file", err)
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+)
+
+// The pretty printer lays out a document built from the primitives below,
+// following Wadler's "prettier printer".  A group is printed on one line if
+// it fits in the remaining width; otherwise its lines become newlines.
+
+type doc interface{}
+
+type docText string
+
+// docLine is a space in flat mode and a newline in broken mode.
+type docLine struct{}
+
+// docHardLine is always a newline, so a group containing one never fits on
+// a single line.
+type docHardLine struct{}
+
+type docNest struct {
+	indent int
+	d      doc
+}
+
+// docAlign sets the indentation of its content to the current column.
+type docAlign struct {
+	d doc
+}
+
+type docGroup struct {
+	d doc
+}
+
+type docConcat []doc
+
+// bodyForms maps the special forms that take a body to the number of
+// arguments kept on the first line.  The body is indented by two columns.
+var bodyForms = map[string]int{
+	"def":      1,
+	"defn":     1,
+	"defmacro": 1,
+	"lambda":   1,
+	"fn":       1,
+	"macro":    1,
+}
+
+const bodyIndent = 2
+
+// DefaultWidth is the line width used by pp when none is given.
+const DefaultWidth = 80
+
+func isComment(expr Expr) bool {
+	_, ok := expr.(*Comment)
+	return ok
+}
+
+// joinLines lays out exprs separated by lines.  If leading is set, a line
+// is put before the first one as well.  A comment is kept on the line of
+// the previous expression when it was there in the source, and is always
+// followed by a newline.
+func joinLines(exprs []Expr, data bool, leading bool) doc {
+	joined := docConcat{}
+	for i, expr := range exprs {
+		if leading || 0 < i {
+			if comment, ok := expr.(*Comment); ok && comment.trailing {
+				joined = append(joined, docText(" "))
+			} else if 0 < i && isComment(exprs[i-1]) {
+				joined = append(joined, docHardLine{})
+			} else {
+				joined = append(joined, docLine{})
+			}
+		}
+		joined = append(joined, exprDoc(expr, data))
+	}
+	if 0 < len(exprs) && isComment(exprs[len(exprs)-1]) {
+		joined = append(joined, docHardLine{})
+	}
+	return joined
+}
+
+// cellDoc lays out a list.  Lists that are data rather than code have all
+// their elements aligned under the first one.
+func cellDoc(cell *Cell, data bool) doc {
+	exprs := []Expr{}
+	cell.Each(func(expr Expr) {
+		exprs = append(exprs, expr)
+	})
+	if symbol, ok := cell.Car().(*Symbol); ok && !data && 1 < len(exprs) {
+		head := docConcat{docText("("), docText(symbol.Name())}
+		if n, ok := bodyForms[symbol.Name()]; ok {
+			if len(exprs)-1 < n {
+				n = len(exprs) - 1
+			}
+			if 0 < n {
+				head = append(head, docText(" "), docAlign{joinLines(exprs[1:1+n], data, false)})
+			}
+			body := joinLines(exprs[1+n:], data, true)
+			return docGroup{docConcat{head, docNest{bodyIndent, body}, docText(")")}}
+		}
+		return docGroup{docConcat{head, docText(" "),
+			docAlign{joinLines(exprs[1:], data, false)}, docText(")")}}
+	}
+	return docGroup{docConcat{docText("("), docAlign{joinLines(exprs, data, false)}, docText(")")}}
+}
+
+func exprDoc(expr Expr, data bool) doc {
+	switch e := expr.(type) {
+	case *Cell:
+		if e == Empty {
+			return docText("()")
+		}
+		return cellDoc(e, data)
+	case *Quoted:
+		return docConcat{docText("'"), exprDoc(e.expr, true)}
+	case *Quasiquoted:
+		return docConcat{docText("`"), exprDoc(e.expr, data)}
+	case *Unquoted:
+		return docConcat{docText(","), exprDoc(e.expr, false)}
+	case *SplicingUnquoted:
+		return docConcat{docText(",@"), exprDoc(e.expr, false)}
+	}
+	return docText(expr.String())
+}
+
+type layoutCommand struct {
+	indent int
+	flat   bool
+	d      doc
+}
+
+// fits reports whether the command, followed by the rest of the stack,
+// can be printed in width columns up to the next newline.
+func fits(width int, command layoutCommand, rest []layoutCommand) bool {
+	commands := []layoutCommand{command}
+	for 0 <= width {
+		if len(commands) == 0 {
+			if len(rest) == 0 {
+				return true
+			}
+			commands = append(commands, rest[len(rest)-1])
+			rest = rest[:len(rest)-1]
+		}
+		c := commands[len(commands)-1]
+		commands = commands[:len(commands)-1]
+		switch d := c.d.(type) {
+		case docText:
+			width -= utf8.RuneCountInString(string(d))
+		case docLine:
+			if !c.flat {
+				return true
+			}
+			width--
+		case docHardLine:
+			return !c.flat
+		case docNest:
+			commands = append(commands, layoutCommand{c.indent + d.indent, c.flat, d.d})
+		case docAlign:
+			commands = append(commands, layoutCommand{c.indent, c.flat, d.d})
+		case docGroup:
+			commands = append(commands, layoutCommand{c.indent, c.flat, d.d})
+		case docConcat:
+			for i := len(d) - 1; 0 <= i; i-- {
+				commands = append(commands, layoutCommand{c.indent, c.flat, d[i]})
+			}
+		}
+	}
+	return false
+}
+
+func layout(d doc, width int) string {
+	buffer := new(bytes.Buffer)
+	column := 0
+	stack := []layoutCommand{{0, false, d}}
+	for 0 < len(stack) {
+		c := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		switch d := c.d.(type) {
+		case docText:
+			buffer.WriteString(string(d))
+			column += utf8.RuneCountInString(string(d))
+		case docLine:
+			if c.flat {
+				buffer.WriteString(" ")
+				column++
+			} else {
+				buffer.WriteString("\n" + strings.Repeat(" ", c.indent))
+				column = c.indent
+			}
+		case docHardLine:
+			buffer.WriteString("\n" + strings.Repeat(" ", c.indent))
+			column = c.indent
+		case docNest:
+			stack = append(stack, layoutCommand{c.indent + d.indent, c.flat, d.d})
+		case docAlign:
+			stack = append(stack, layoutCommand{column, c.flat, d.d})
+		case docGroup:
+			flat := layoutCommand{c.indent, true, d.d}
+			if c.flat || fits(width-column, flat, stack) {
+				stack = append(stack, flat)
+			} else {
+				stack = append(stack, layoutCommand{c.indent, false, d.d})
+			}
+		case docConcat:
+			for i := len(d) - 1; 0 <= i; i-- {
+				stack = append(stack, layoutCommand{c.indent, c.flat, d[i]})
+			}
+		}
+	}
+	return buffer.String()
+}
+
+// Pretty returns the printed representation of expr, broken into lines and
+// indented so that it fits in width columns where possible.
+func Pretty(expr Expr, width int) string {
+	return layout(exprDoc(expr, false), width)
+}
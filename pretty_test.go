@@ -0,0 +1,41 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"testing"
+)
+
+type prettyTestCase struct {
+	input  string
+	width  int
+	output string
+}
+
+var prettyTestCases = []prettyTestCase{
+	prettyTestCase{"(a b c)", 80, "(a b c)"},
+	prettyTestCase{"(foo bar baz)", 10, "(foo bar\n     baz)"},
+	prettyTestCase{"((a b) c d)", 6, "((a b)\n c\n d)"},
+	prettyTestCase{"(defn (f x) (g x) (h x))", 80, "(defn (f x) (g x) (h x))"},
+	prettyTestCase{"(defn (f x) (g x) (h x))", 20, "(defn (f x)\n  (g x)\n  (h x))"},
+	prettyTestCase{"(lambda (x) (if (empty? x) 0 1))", 20,
+		"(lambda (x)\n  (if (empty? x)\n      0\n      1))"},
+	prettyTestCase{"(if (a) (b c d) (e f g))", 18, "(if (a)\n    (b c d)\n    (e f g))"},
+	prettyTestCase{"'(aaaa bbbb cccc)", 12, "'(aaaa\n  bbbb\n  cccc)"},
+	prettyTestCase{"(f \"hello world\")", 5, "(f \"hello world\")"},
+}
+
+func TestPretty(t *testing.T) {
+	for _, tc := range prettyTestCases {
+		expr, _, err := ReadFromString(tc.input)
+		if err != nil {
+			t.Errorf("input: [[%v]], ERROR: [[%v]]", tc.input, err)
+			continue
+		}
+		if received := Pretty(expr, tc.width); received != tc.output {
+			t.Errorf("expected: [[%v]], received: [[%v]]", tc.output, received)
+		}
+	}
+}
@@ -7,19 +7,70 @@ package yall
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"io"
 	"strconv"
 	"strings"
+	"unicode"
 )
 
 type reader struct {
 	input *bufio.Reader
+	table *readtable
+	// keepComments makes the reader return comments as *Comment instead of
+	// skipping them.
+	keepComments bool
+	// commentTrailing is set when the last comment read followed other
+	// tokens on the same line.
+	commentTrailing bool
+	// inLine is set when something other than whitespace has been read
+	// since the last newline.
+	inLine bool
+	// env is the environment reading, whose evaluation the reader macros
+	// written in yall run in.
+	env *Env
+}
+
+func (r *reader) readtable() *readtable {
+	if r.table == nil {
+		return standardReadtable
+	}
+	return r.table
 }
 
 func (r *reader) setInput(input io.Reader) {
 	r.input = bufio.NewReader(input)
 }
 
+// unescape replaces the escape sequences in the body of a string literal
+// with the characters they stand for.
+func unescape(s string) string {
+	if !strings.ContainsRune(s, '\\') {
+		return s
+	}
+	buffer := new(bytes.Buffer)
+	escaped := false
+	for _, rune := range s {
+		if !escaped && rune == '\\' {
+			escaped = true
+			continue
+		}
+		if escaped {
+			switch rune {
+			case 'n':
+				rune = '\n'
+			case 't':
+				rune = '\t'
+			case 'r':
+				rune = '\r'
+			}
+			escaped = false
+		}
+		buffer.WriteRune(rune)
+	}
+	return buffer.String()
+}
+
 func (r *reader) nextString() (token string, size int, err error) {
 	buffer := bytes.NewBufferString("\"")
 	size = 1
@@ -36,15 +87,54 @@ func (r *reader) nextString() (token string, size int, err error) {
 			if !escaped {
 				return buffer.String(), size, nil
 			}
+			escaped = false
 		case '\\':
-			escaped = true
+			escaped = !escaped
 			buffer.WriteRune(rune)
 		default:
 			escaped = false
 			buffer.WriteRune(rune)
 		}
 	}
-	return "", 0, NewSyntaxError("Unexpected EOS in string")
+	return "", 0, ErrIncomplete
+}
+
+// skipComment reads the rest of a comment that starts with ';' and returns
+// it, including the ';' but not the newline that ends it.
+func (r *reader) skipComment() (comment string, size int) {
+	buffer := bytes.NewBufferString(";")
+	for {
+		rune, s, err := r.input.ReadRune()
+		size += s
+		if err != nil {
+			break
+		}
+		if '\n' == rune {
+			r.input.UnreadRune()
+			size -= s
+			break
+		}
+		buffer.WriteRune(rune)
+	}
+	return strings.TrimRightFunc(buffer.String(), unicode.IsSpace), size
+}
+
+// skipSpace consumes whitespace and returns the number of newlines in it.
+func (r *reader) skipSpace() (newlines int) {
+	for {
+		rune, _, err := r.input.ReadRune()
+		if err != nil {
+			return
+		}
+		if !unicode.IsSpace(rune) {
+			r.input.UnreadRune()
+			return
+		}
+		if '\n' == rune {
+			newlines++
+			r.inLine = false
+		}
+	}
 }
 
 func (r *reader) nextToken() (token string, size int, err error) {
@@ -59,8 +149,14 @@ func (r *reader) nextToken() (token string, size int, err error) {
 			}
 			return "", 0, err
 		}
+		inLine := r.inLine
+		if '\n' == rune {
+			r.inLine = false
+		} else if !unicode.IsSpace(rune) {
+			r.inLine = true
+		}
 		switch rune {
-		case '(', ')', '[', ']', '\'', '`':
+		case '(', ')', '[', ']':
 			if 0 < buffer.Len() {
 				r.input.UnreadRune()
 				size -= s
@@ -91,16 +187,39 @@ func (r *reader) nextToken() (token string, size int, err error) {
 				return buffer.String(), size, nil
 			}
 			return r.nextString()
+		case ';':
+			if 0 < buffer.Len() {
+				r.input.UnreadRune()
+				r.inLine = inLine
+				size -= s
+				return buffer.String(), size, nil
+			}
+			comment, commentSize := r.skipComment()
+			size += commentSize
+			if r.keepComments {
+				r.commentTrailing = inLine
+				return comment, size, nil
+			}
 		default:
+			if r.readtable().isMacroCharacter(rune) {
+				if 0 < buffer.Len() {
+					r.input.UnreadRune()
+					size -= s
+					return buffer.String(), size, nil
+				}
+				return string(rune), size, nil
+			}
 			buffer.WriteRune(rune)
 		}
 	}
-	return "", 0, NewSyntaxError("?")
 }
 
 func (r *reader) readTokens(asList bool) (Expr, int, error) {
 	token, size, err := r.nextToken()
 	if nil != err {
+		if asList && err == io.EOF {
+			err = ErrIncomplete
+		}
 		return False, 0, err
 	}
 	var expr Expr
@@ -115,36 +234,36 @@ func (r *reader) readTokens(asList bool) (Expr, int, error) {
 		}
 		expr = Empty
 		asList = false
-	} else if "'" == token {
-		rest, restSize, restErr := r.readTokens(false)
-		expr = NewQuoted(rest)
-		size += restSize
-		err = restErr
-	} else if "`" == token {
-		rest, restSize, restErr := r.readTokens(false)
-		expr = NewQuasiquoted(rest)
-		size += restSize
-		err = restErr
-	} else if "," == token {
-		rest, restSize, restErr := r.readTokens(false)
-		expr = NewUnquoted(rest)
-		size += restSize
-		err = restErr
-	} else if ",@" == token {
-		list, listSize, listErr := r.readTokens(false)
-		expr = NewSplicingUnquoted(list)
-		size += listSize
-		err = listErr
+	} else if m, ok := r.readtable().lookup(token); ok {
+		macroExpr, macroSize, macroErr := m(r, token)
+		expr = macroExpr
+		size += macroSize
+		err = macroErr
 	} else if i, ierr := strconv.Atoi(token); ierr == nil {
 		expr = NewInteger(i)
+	} else if errors.Is(ierr, strconv.ErrRange) {
+		panic(NewSyntaxError("Integer out of range: " + token))
+	} else if f, ok := floatNames[token]; ok {
+		expr = NewFloat(f)
+	} else if isFloat(token) {
+		f, _ := strconv.ParseFloat(token, 64)
+		expr = NewFloat(f)
+	} else if strings.HasPrefix(token, ";") {
+		expr = NewComment(token, r.commentTrailing)
 	} else if isString(token) {
-		expr = NewString(token[1 : len(token)-1])
+		expr = NewString(unescape(token[1 : len(token)-1]))
 	} else {
 		expr = NewSymbol(token)
 	}
+	if err != nil {
+		return expr, size, err
+	}
 	if asList {
 		cdr, cdrSize, cdrErr := r.readTokens(true)
-		return NewCell(expr, cdr.(*Cell)), size + cdrSize, cdrErr
+		if cdrErr != nil {
+			return cdr, size + cdrSize, cdrErr
+		}
+		return NewCell(expr, cdr.(*Cell)), size + cdrSize, nil
 	}
 	return expr, size, nil
 }
@@ -158,6 +277,51 @@ func (r *reader) Read() (Expr, int, error) {
 	return r.readTokens(false)
 }
 
+// Reader reads a sequence of expressions from an input stream.  Unlike Read,
+// it keeps its buffered input between calls.
+type Reader struct {
+	r *reader
+}
+
+func NewReader(input io.Reader) *Reader {
+	return &Reader{&reader{input: bufio.NewReader(input)}}
+}
+
+// Next returns the next expression in the input.  It returns io.EOF when
+// the input is exhausted, and ErrIncomplete when the input ends in the
+// middle of an expression.
+func (r *Reader) Next() (expr Expr, err error) {
+	defer func() {
+		if e := recover(); e != nil {
+			serr, ok := e.(*SyntaxError)
+			if !ok {
+				panic(e)
+			}
+			expr, err = nil, serr
+		}
+	}()
+	expr, _, err = r.r.readTokens(false)
+	if err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+// ReadAll reads the rest of the input and returns every expression in it.
+func (r *Reader) ReadAll() ([]Expr, error) {
+	exprs := []Expr{}
+	for {
+		expr, err := r.Next()
+		if err == io.EOF {
+			return exprs, nil
+		}
+		if err != nil {
+			return exprs, err
+		}
+		exprs = append(exprs, expr)
+	}
+}
+
 func Read(input io.Reader) (expr Expr, size int, err error) {
 	return new(reader).read(input)
 }
@@ -5,6 +5,8 @@
 package yall
 
 import (
+	"io"
+	"math/rand"
 	"strings"
 	"testing"
 )
@@ -64,6 +66,11 @@ var readTestCases = []readTestCase{
 	readTestCase{"`(a ,b c)", "`(a ,b c)", 9},
 	readTestCase{"'a", "'a", 2},
 	readTestCase{"`(a ,b ,@(c d))", "`(a ,b ,@(c d))", 15},
+	readTestCase{"\"a\\\"b\"", "\"a\\\"b\"", 6},
+	readTestCase{"\"a\\\\\"", "\"a\\\\\"", 5},
+	readTestCase{"\"a\\nb\"", "\"a\\nb\"", 6},
+	readTestCase{"-inf.0", "-inf.0", 6},
+	readTestCase{"(+nan.0)", "(+nan.0)", 8},
 }
 
 func TestRead(t *testing.T) {
@@ -78,3 +85,154 @@ func TestRead(t *testing.T) {
 		}
 	}
 }
+
+func TestReadtable(t *testing.T) {
+	table := standardReadtable.copy()
+	table.set("^", wrappingMacro(func(expr Expr) Expr {
+		return NewCell(NewSymbol("hat"), NewCell(expr, Empty))
+	}))
+	table.set("#pair", func(r *reader, key string) (Expr, int, error) {
+		car, carSize, _ := r.readTokens(false)
+		cdr, cdrSize, err := r.readTokens(false)
+		return NewCell(car, NewCell(cdr, Empty)), carSize + cdrSize, err
+	})
+	for _, tc := range []readTestCase{
+		readTestCase{"^a", "(hat a)", 2},
+		readTestCase{"(a^b)", "(a (hat b))", 5},
+		readTestCase{"'^a", "'(hat a)", 3},
+		readTestCase{"#pair 1 2", "(1 2)", 9},
+		readTestCase{"#pair\"a\"b", "(\"a\" b)", 9},
+	} {
+		r := &reader{table: table}
+		expr, size, err := r.read(strings.NewReader(tc.input))
+		if err != nil {
+			t.Errorf("input: [[%v]], ERROR: [[%v]]", tc.input, err)
+		} else if expr.String() != tc.output {
+			t.Errorf("expected: [[%v]], received: [[%v]]", tc.output, expr)
+		} else if size != tc.size {
+			t.Errorf("input: [[%v]], expected size: [[%v]], received size: [[%v]]", tc.input, tc.size, size)
+		}
+	}
+}
+
+func TestReaderNext(t *testing.T) {
+	r := NewReader(strings.NewReader("(a b) 1 \"c\"\n'd "))
+	for _, expected := range []string{"(a b)", "1", "\"c\"", "'d"} {
+		expr, err := r.Next()
+		if err != nil {
+			t.Errorf("expected: [[%v]], received: !!ERROR!! (%v)", expected, err)
+		} else if expr.String() != expected {
+			t.Errorf("expected: [[%v]], received: [[%v]]", expected, expr)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("expected: io.EOF, received: [[%v]]", err)
+	}
+}
+
+func TestReaderReadAll(t *testing.T) {
+	exprs, err := NewReader(strings.NewReader("a (b c) d")).ReadAll()
+	if err != nil {
+		t.Errorf("ERROR: [[%v]]", err)
+	}
+	if len(exprs) != 3 {
+		t.Errorf("expected 3 expressions, received %v", exprs)
+	}
+	if _, err := NewReader(strings.NewReader("a)")).ReadAll(); err == nil {
+		t.Errorf("expected a syntax error")
+	}
+}
+
+func TestReaderIncomplete(t *testing.T) {
+	for _, input := range []string{"(a b", "(a (b c)", "\"abc", "'", "(a '", "`(a ,"} {
+		if _, err := NewReader(strings.NewReader(input)).Next(); err != ErrIncomplete {
+			t.Errorf("input: [[%v]], expected: ErrIncomplete, received: [[%v]]", input, err)
+		}
+	}
+	if _, err := NewReader(strings.NewReader(")")).Next(); err == ErrIncomplete {
+		t.Errorf("input: [[)]], expected: SyntaxError, received: [[%v]]", err)
+	} else if _, ok := err.(*SyntaxError); !ok {
+		t.Errorf("input: [[)]], expected: SyntaxError, received: [[%v]]", err)
+	}
+	if _, err := NewReader(strings.NewReader("99999999999999999999")).Next(); err == nil || err.Error() != "Integer out of range: 99999999999999999999" {
+		t.Errorf("input: [[99999999999999999999]], expected: SyntaxError, received: [[%v]]", err)
+	}
+}
+
+var randomRunes = []rune("ab ()';\"\\\n\tあ")
+var randomSymbolRunes = []rune("abcxyz-+*?!")
+
+func randomExpr(rnd *rand.Rand, depth int) Expr {
+	n := 4
+	if 0 < depth {
+		n = 6
+	}
+	switch rnd.Intn(n) {
+	case 0:
+		return NewInteger(rnd.Intn(2000) - 1000)
+	case 1:
+		runes := make([]rune, rnd.Intn(8))
+		for i := range runes {
+			runes[i] = randomRunes[rnd.Intn(len(randomRunes))]
+		}
+		return NewString(string(runes))
+	case 2:
+		runes := []rune{'s'}
+		for i := rnd.Intn(6); 0 < i; i-- {
+			runes = append(runes, randomSymbolRunes[rnd.Intn(len(randomSymbolRunes))])
+		}
+		return NewSymbol(string(runes))
+	case 3:
+		return Empty
+	case 4:
+		return NewQuoted(randomExpr(rnd, depth-1))
+	}
+	cell := Empty
+	for i := rnd.Intn(5); 0 < i; i-- {
+		cell = NewCell(randomExpr(rnd, depth-1), cell)
+	}
+	return cell
+}
+
+func sameExpr(a, b Expr) bool {
+	switch x := a.(type) {
+	case *Integer:
+		y, ok := b.(*Integer)
+		return ok && x.value == y.value
+	case *String:
+		y, ok := b.(*String)
+		return ok && x.value == y.value
+	case *Symbol:
+		y, ok := b.(*Symbol)
+		return ok && x.name == y.name
+	case *Quoted:
+		y, ok := b.(*Quoted)
+		return ok && sameExpr(x.expr, y.expr)
+	case *Cell:
+		y, ok := b.(*Cell)
+		if !ok {
+			return false
+		}
+		for ; x != Empty && y != Empty; x, y = x.cdr, y.cdr {
+			if !sameExpr(x.car, y.car) {
+				return false
+			}
+		}
+		return x == Empty && y == Empty
+	}
+	return false
+}
+
+// Everything written reads back as the same expression.
+func TestWriteRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		expr := randomExpr(rnd, 4)
+		read, _, err := ReadFromString(expr.String())
+		if err != nil {
+			t.Errorf("input: [[%v]], ERROR: [[%v]]", expr, err)
+		} else if !sameExpr(expr, read) {
+			t.Errorf("expected: [[%v]], received: [[%v]]", expr, read)
+		}
+	}
+}
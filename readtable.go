@@ -0,0 +1,108 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"io"
+	"sync"
+)
+
+// A readerMacro is called by the reader right after it has consumed the
+// macro's key.  It reads whatever it needs from r and returns the resulting
+// expression and the number of bytes it consumed.
+type readerMacro func(r *reader, key string) (Expr, int, error)
+
+// readtable maps macro keys to reader macros.  A key is either a single
+// character, which terminates the preceding token like a parenthesis, or a
+// dispatch sequence such as "#r", which is recognized as a whole token.
+type readtable struct {
+	lock   sync.RWMutex
+	macros map[string]readerMacro
+}
+
+func newReadtable() *readtable {
+	return &readtable{macros: make(map[string]readerMacro)}
+}
+
+func wrappingMacro(wrap func(Expr) Expr) readerMacro {
+	return func(r *reader, key string) (Expr, int, error) {
+		expr, size, err := r.readTokens(false)
+		if err == io.EOF {
+			return expr, size, ErrIncomplete
+		}
+		if err != nil {
+			return expr, size, err
+		}
+		return wrap(expr), size, nil
+	}
+}
+
+var standardReadtable = newReadtable()
+
+func init() {
+	standardReadtable.macros["'"] = wrappingMacro(func(expr Expr) Expr {
+		return NewQuoted(expr)
+	})
+	standardReadtable.macros["`"] = wrappingMacro(func(expr Expr) Expr {
+		return NewQuasiquoted(expr)
+	})
+	standardReadtable.macros[","] = wrappingMacro(func(expr Expr) Expr {
+		return NewUnquoted(expr)
+	})
+	standardReadtable.macros[",@"] = wrappingMacro(func(expr Expr) Expr {
+		return NewSplicingUnquoted(expr)
+	})
+}
+
+func (table *readtable) copy() *readtable {
+	table.lock.RLock()
+	defer table.lock.RUnlock()
+	c := newReadtable()
+	for key, m := range table.macros {
+		c.macros[key] = m
+	}
+	return c
+}
+
+func (table *readtable) lookup(key string) (readerMacro, bool) {
+	table.lock.RLock()
+	defer table.lock.RUnlock()
+	m, ok := table.macros[key]
+	return m, ok
+}
+
+func (table *readtable) isMacroCharacter(r rune) bool {
+	table.lock.RLock()
+	defer table.lock.RUnlock()
+	_, ok := table.macros[string(r)]
+	return ok
+}
+
+func (table *readtable) set(key string, m readerMacro) {
+	switch key {
+	case "", "(", ")", "[", "]", "\"", ",", ",@":
+		panic(NewRuntimeError("Can't redefine reader syntax: " + key))
+	}
+	table.lock.Lock()
+	defer table.lock.Unlock()
+	table.macros[key] = m
+}
+
+// setFunction registers a yall function as the reader macro for key.  The
+// function is called with an input port positioned right after the key and
+// the key itself, as part of the evaluation reading.
+func (table *readtable) setFunction(key string, function *Function) {
+	table.set(key, func(r *reader, key string) (Expr, int, error) {
+		port := newInputPort(r)
+		args := NewCell(port, NewCell(NewString(key), Empty))
+		var expr Expr
+		if r.env != nil {
+			expr = function.call(r.env, args)
+		} else {
+			expr = function.Apply(args)
+		}
+		return expr, port.consumed, nil
+	})
+}
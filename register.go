@@ -0,0 +1,134 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+)
+
+var (
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+// Register interns fn, which must be a Go function, as the yall function
+// name.  When called, the arguments are converted to the parameter types of
+// fn as ToGo does, and the results are converted back as FromGo does: no
+// result becomes True, one result becomes its expression and several
+// results become a list.  Pointers, functions, channels and interfaces with
+// methods are returned as a GoValue.  A non-nil error as the last result
+// raises a yall error instead, and so does a panic in fn.
+//
+// If the first parameter of fn is a context.Context, fn is given the
+// context of the evaluation calling it, which is done when the evaluation
+// is cancelled or runs out of time, and the arguments go to the other
+// parameters.  An error returned once the context is done stops the
+// evaluation like its context does, rather than raising a yall error.
+func (env *Env) Register(name string, fn interface{}) {
+	f := reflect.ValueOf(fn)
+	if f.Kind() != reflect.Func || f.IsNil() {
+		panic(NewRuntimeError("Can't register " + name + ": not a function"))
+	}
+	env.internEnvFunction(name, wrapGoFunction(name, f))
+}
+
+func wrapGoFunction(name string, f reflect.Value) func(*Env, *Cell) Expr {
+	return func(env *Env, args *Cell) Expr {
+		return callGo(env.currentContext(), name, f, args)
+	}
+}
+
+// callGo calls the Go function f with args converted to its parameter
+// types and returns its results converted to an expression.  ctx is given
+// to f if it takes a context.Context first.
+func callGo(ctx context.Context, name string, f reflect.Value, args *Cell) Expr {
+	t := f.Type()
+	in := []reflect.Value{}
+	if 0 < t.NumIn() && t.In(0) == contextType {
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	given := len(in)
+	for c := args; c != Empty; c = c.cdr {
+		n := len(in)
+		var paramType reflect.Type
+		switch {
+		case n < t.NumIn()-1 || (n == t.NumIn()-1 && !t.IsVariadic()):
+			paramType = t.In(n)
+		case t.IsVariadic():
+			paramType = t.In(t.NumIn() - 1).Elem()
+		default:
+			panic(NewRuntimeError("Too many arguments to " + name + ", " + arity(t, given) + " required"))
+		}
+		v := reflect.New(paramType).Elem()
+		if err := toValue(c.car, v); err != nil {
+			panic(NewRuntimeError(name + ": argument " + strconv.Itoa(n-given+1) + ": " + err.Error()))
+		}
+		in = append(in, v)
+	}
+	min := t.NumIn()
+	if t.IsVariadic() {
+		min--
+	}
+	if len(in) < min {
+		panic(NewRuntimeError("Too few arguments to " + name + ", " + arity(t, given) + " required"))
+	}
+	out := invoke(name, f, in)
+	if 0 < len(out) && t.Out(len(out)-1) == errorType {
+		if err := out[len(out)-1]; !err.IsNil() {
+			if ctx.Err() != nil {
+				panic(ctx.Err())
+			}
+			panic(NewRuntimeError(name + ": " + err.Interface().(error).Error()))
+		}
+		out = out[:len(out)-1]
+	}
+	exprs := make([]Expr, len(out))
+	for i, v := range out {
+		expr, err := goResult(v)
+		if err != nil {
+			panic(NewRuntimeError(name + ": " + err.Error()))
+		}
+		exprs[i] = expr
+	}
+	switch len(exprs) {
+	case 0:
+		return True
+	case 1:
+		return exprs[0]
+	}
+	return listOf(exprs)
+}
+
+// invoke calls f with in, raising a panic in f as a yall error so that try
+// can catch it.  The errors of yall itself, from evaluations f makes, go
+// through unchanged.
+func invoke(name string, f reflect.Value, in []reflect.Value) []reflect.Value {
+	defer func() {
+		if r := recover(); r != nil {
+			switch r {
+			case ErrBudgetExceeded, context.Canceled, context.DeadlineExceeded:
+				panic(r)
+			}
+			switch r.(type) {
+			case *RuntimeError, *SyntaxError:
+				panic(r)
+			}
+			panic(NewRuntimeError(name + ": " + fmt.Sprint(r)))
+		}
+	}()
+	return f.Call(in)
+}
+
+// arity describes the number of arguments f of type t takes, given is the
+// number of parameters given by callGo itself.
+func arity(t reflect.Type, given int) string {
+	if t.IsVariadic() {
+		return fmt.Sprintf("at least %d", t.NumIn()-given-1)
+	}
+	return fmt.Sprintf("exactly %d", t.NumIn()-given)
+}
@@ -0,0 +1,53 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestRegister(t *testing.T) {
+	env := NewEnv()
+	env.Register("repeat", func(s string, n int) string {
+		return strings.Repeat(s, n)
+	})
+	env.Register("checked-div", func(a, b int) (int, error) {
+		if b == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return a / b, nil
+	})
+	env.Register("sum", func(xs ...float64) float64 {
+		total := 0.0
+		for _, x := range xs {
+			total += x
+		}
+		return total
+	})
+	env.Register("split", func(s string) (string, string) {
+		return s[:1], s[1:]
+	})
+	env.Register("noop", func() {})
+	for _, tc := range []evalTestCase{
+		evalTestCase{"(repeat \"ab\" 3)", "\"ababab\""},
+		evalTestCase{"(checked-div 7 2)", "3"},
+		evalTestCase{"(try (checked-div 1 0) error-message)", "\"checked-div: division by zero\""},
+		evalTestCase{"(sum)", "0.0"},
+		evalTestCase{"(sum 1 2 0.5)", "3.5"},
+		evalTestCase{"(split \"xyz\")", "(\"x\" \"yz\")"},
+		evalTestCase{"(noop)", "#t"},
+		evalTestCase{"(try (repeat \"a\") error-message)", "\"Too few arguments to repeat, exactly 2 required\""},
+		evalTestCase{"(try (repeat \"a\" 1 2) error-message)", "\"Too many arguments to repeat, exactly 2 required\""},
+		evalTestCase{"(try (repeat 1 2) error-message)", "\"repeat: argument 1: Cannot convert 1 to string\""},
+		evalTestCase{"(try (repeat \"a\" -1) error-message)", "\"repeat: strings: negative Repeat count\""},
+		evalTestCase{"(try (split \"\") error-message)", "\"split: runtime error: slice bounds out of range [:1] with length 0\""},
+	} {
+		if expr := env.EvalString(tc.input); expr.String() != tc.output {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+		}
+	}
+}
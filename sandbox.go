@@ -0,0 +1,158 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"io"
+	"io/fs"
+	"os"
+)
+
+// Builtins is a set of groups of builtin functions and special forms.
+type Builtins int
+
+const (
+	// PureBuiltins are the core language, list and arithmetic functions,
+	// errors, JSON, and calls on Go values given by the host.
+	PureBuiltins Builtins = 1 << iota
+	// IOBuiltins read and write ports, including the current input and
+	// output ports.
+	IOBuiltins
+	// FSBuiltins access the host file system, and include load and
+	// require.
+	FSBuiltins
+	// OSBuiltins access the process, like getenv and exit.
+	OSBuiltins
+	// ConcurrencyBuiltins are go, select and channels.
+	ConcurrencyBuiltins
+	// ModuleBuiltins make the modules registered by RegisterModule and
+	// RegisterModuleIn available, each if the builtins it needs are
+	// installed as well.
+	ModuleBuiltins
+
+	AllBuiltins = PureBuiltins | IOBuiltins | FSBuiltins | OSBuiltins | ConcurrencyBuiltins | ModuleBuiltins
+)
+
+// SandboxOptions configures NewSandboxEnv.
+type SandboxOptions struct {
+	// Builtins are the groups of builtins to install.
+	Builtins Builtins
+	// FS, if not nil, is the file system load reads from instead of the
+	// host's.  load and require are then installed even without
+	// FSBuiltins.
+	FS fs.FS
+}
+
+// osFunctions are the builtin functions that access the process.
+var osFunctions = map[string]func(*Cell) Expr{
+
+	"getenv": func(args *Cell) Expr {
+		if value, ok := os.LookupEnv(stringArg("getenv", args.Car())); ok {
+			return NewString(value)
+		}
+		return False
+	},
+
+	"exit": func(args *Cell) Expr {
+		code := 0
+		if Empty != args {
+			integer, ok := args.Car().(*Integer)
+			if !ok {
+				panic(NewRuntimeError("exit requires an integer, but got " + printable(args.Car())))
+			}
+			code = integer.Value()
+		}
+		os.Exit(code)
+		return nil
+	},
+}
+
+// NewSandboxEnv returns a new Env with only the builtins chosen by
+// options.  The standard library is loaded if PureBuiltins are installed,
+// as it is written with them.
+func NewSandboxEnv(options SandboxOptions) *Env {
+	env := newBuiltinEnv(options)
+	if options.Builtins&PureBuiltins != 0 {
+		env.loadPrelude()
+	}
+	return env
+}
+
+// newBuiltinEnv returns a new Env with the builtins chosen by options, and
+// without the standard library.
+func newBuiltinEnv(options SandboxOptions) *Env {
+	env := new(Env)
+	env.values = make(map[string]Expr)
+	env.parent = nil
+	env.table = standardReadtable.copy()
+	env.input = NewInputPort(os.Stdin)
+	env.output = NewOutputPort(os.Stdout)
+	env.fsys = options.FS
+	env.builtins = options.Builtins
+	env.internVariable("#t", True)
+	env.internVariable("#f", False)
+	if options.Builtins&PureBuiltins != 0 {
+		for name, form := range specialForms {
+			env.internSpecialForm(name, form)
+		}
+		for name, function := range builtinFunctions {
+			env.internFunction(name, function)
+		}
+		for name, function := range jsonFunctions {
+			env.internFunction(name, function)
+		}
+		for name, form := range goSpecialForms {
+			env.internSpecialForm(name, form)
+		}
+		for name, form := range moduleSpecialForms {
+			env.internSpecialForm(name, form)
+		}
+	}
+	if options.Builtins&IOBuiltins != 0 {
+		for name, function := range ioFunctions {
+			env.internEnvFunction(name, function)
+		}
+	}
+	if options.Builtins&FSBuiltins != 0 {
+		for name, function := range fileFunctions {
+			env.internEnvFunction(name, function)
+		}
+		for name, form := range fileSpecialForms {
+			env.internSpecialForm(name, form)
+		}
+	}
+	if options.Builtins&FSBuiltins != 0 || options.FS != nil {
+		env.internSpecialForm("load", load)
+		env.internSpecialForm("require", require)
+	}
+	if options.Builtins&OSBuiltins != 0 {
+		for name, function := range osFunctions {
+			env.internFunction(name, function)
+		}
+	}
+	if options.Builtins&ConcurrencyBuiltins != 0 {
+		for name, function := range concurrencyFunctions {
+			env.internEnvFunction(name, function)
+		}
+		for name, form := range concurrencySpecialForms {
+			env.internSpecialForm(name, form)
+		}
+	}
+	return env
+}
+
+// openFile opens the file name for load, from the file system of the
+// sandbox if there is one.  Without one, the host file system can only be
+// read if FSBuiltins are installed.
+func (env *Env) openFile(name string) (io.ReadCloser, error) {
+	root := env.root()
+	if root.fsys != nil {
+		return root.fsys.Open(name)
+	}
+	if root.builtins&FSBuiltins == 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	return os.Open(name)
+}
@@ -0,0 +1,51 @@
+// Copyright 2012 Yuichi Araki. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package yall
+
+import (
+	"testing"
+	"testing/fstest"
+)
+
+func TestSandbox(t *testing.T) {
+	fsys := fstest.MapFS{
+		"rules/square.yall": &fstest.MapFile{Data: []byte("(defn (square x) (* x x))")},
+	}
+	env := NewSandboxEnv(SandboxOptions{Builtins: PureBuiltins, FS: fsys})
+	for _, tc := range []evalTestCase{
+		evalTestCase{"(map (fn (x) (+ x 1)) '(1 2 3))", "(2 3 4)"},
+		evalTestCase{"(load \"rules/square.yall\")", "#t"},
+		evalTestCase{"(square 7)", "49"},
+		evalTestCase{"(try (load \"../etc/passwd\") error-message)",
+			"\"Cannot load: \\\"../etc/passwd\\\": open ../etc/passwd: file does not exist\""},
+		evalTestCase{"(try (load \"/etc/passwd\") error-message)",
+			"\"Cannot load: \\\"/etc/passwd\\\": open /etc/passwd: file does not exist\""},
+		evalTestCase{"(try (open-input-file \"/etc/passwd\") error-message)",
+			"\"Unbound variable: open-input-file\""},
+		evalTestCase{"(try (println 1) error-message)", "\"Unbound variable: println\""},
+		evalTestCase{"(try (getenv \"HOME\") error-message)", "\"Unbound variable: getenv\""},
+		evalTestCase{"(try (make-chan) error-message)", "\"Unbound variable: make-chan\""},
+	} {
+		if expr := env.EvalString(tc.input); expr.String() != tc.output {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+		}
+	}
+}
+
+func TestSandboxGroups(t *testing.T) {
+	env := NewSandboxEnv(SandboxOptions{Builtins: PureBuiltins | IOBuiltins | OSBuiltins})
+	for _, tc := range []evalTestCase{
+		evalTestCase{"(with-output-to-string (fn () (display \"ok\")))", "\"ok\""},
+		evalTestCase{"(getenv \"YALL_SURELY_UNSET\")", "#f"},
+		evalTestCase{"(try (load \"sys.yall\") error-message)", "\"Unbound variable: load\""},
+	} {
+		if expr := env.EvalString(tc.input); expr.String() != tc.output {
+			t.Errorf("%v: Received [[%v]] when expecting [[%v]]", tc.input, expr, tc.output)
+		}
+	}
+	if _, ok := NewSandboxEnv(SandboxOptions{}).values["car"]; ok {
+		t.Errorf("An empty sandbox has car")
+	}
+}
@@ -4,10 +4,6 @@
 
 package yall
 
-import (
-	"os"
-)
-
 func bindLambdaList(env *Env, lambdaList *Cell, args *Cell) {
 	for c := lambdaList; c != Empty; c = c.cdr {
 		e := c.car
@@ -34,24 +30,81 @@ func bindLambdaList(env *Env, lambdaList *Cell, args *Cell) {
 	}
 }
 
+// closure is a function or macro body together with the Env it was
+// defined in.  Builtins that are given the Env they were installed in are
+// closures with a builtin instead of a body.
+type closure struct {
+	env        *Env
+	lambdaList *Cell
+	body       *Cell
+	builtin    func(*Env, *Cell) Expr
+}
+
+func (c *closure) call(args *Cell) Expr {
+	return c.callWith(nil, args)
+}
+
+// callWith applies c to args with the dynamic state d of the caller.
+func (c *closure) callWith(d *dynamic, args *Cell) Expr {
+	if c.builtin != nil {
+		if d == nil {
+			return c.builtin(c.env, args)
+		}
+		return c.builtin(c.env.overlayWith(d), args)
+	}
+	derived := c.env.Derive()
+	derived.dynamic = d
+	bindLambdaList(derived, c.lambdaList, args)
+	return derived.Begin(c.body)
+}
+
+func newClosureFunction(name string, c *closure) *Function {
+	function := NewFunction(name, c.call)
+	function.closure = c
+	return function
+}
+
+func newClosureMacro(name string, c *closure) *Macro {
+	macro := NewMacro(name, c.call)
+	macro.closure = c
+	return macro
+}
+
 func lambda(env *Env, args *Cell) Expr {
-	lambdaList := args.Car().(*Cell)
-	body := args.Cdr()
-	return NewFunction("#lambda", func(args *Cell) Expr {
-		derived := env.Derive()
-		bindLambdaList(derived, lambdaList, args)
-		return derived.Begin(body)
-	})
+	return newClosureFunction("#lambda", &closure{env: env.lexical(), lambdaList: args.Car().(*Cell), body: args.Cdr()})
 }
 
 func macro(env *Env, args *Cell) Expr {
-	lambdaList := args.Car().(*Cell)
-	body := args.Cdr()
-	return NewMacro("#macro", func(args *Cell) Expr {
-		derived := env.Derive()
-		bindLambdaList(derived, lambdaList, args)
-		return derived.Begin(body)
+	return newClosureMacro("#macro", &closure{env: env.lexical(), lambdaList: args.Car().(*Cell), body: args.Cdr()})
+}
+
+// load evaluates the files named by its arguments.  It is installed with
+// the file system builtins, or on its own in a sandbox given a file system.
+func load(env *Env, args *Cell) Expr {
+	return loadEach(env, "load", args, func(filename string) error {
+		return env.loadFile(filename, false)
+	})
+}
+
+// require is like load, but evaluates each file in the global environment
+// only the first time it is required.
+func require(env *Env, args *Cell) Expr {
+	return loadEach(env, "require", args, func(filename string) error {
+		return env.global().loadFile(filename, true)
+	})
+}
+
+func loadEach(env *Env, name string, args *Cell, f func(string) error) Expr {
+	args.Each(func(expr Expr) {
+		if filename, ok := env.Eval(expr).(*String); ok {
+			if err := f(filename.value); err != nil {
+				panic(NewRuntimeError("Cannot " + name + ": " + filename.String() + ": " + err.Error()))
+			}
+		} else {
+			panic(NewRuntimeError("Cannot " + name + ": " + expr.String()))
+		}
 	})
+	return True
 }
 
 var specialForms = map[string]func(*Env, *Cell) Expr{
@@ -114,24 +167,77 @@ var specialForms = map[string]func(*Env, *Cell) Expr{
 		if !ok {
 			panic(NewRuntimeError("inc! requires a symbol"))
 		}
-		integer, ok := env.Eval(symbol).(*Integer)
-		integer.setValue(integer.Value() + 1)
-		return integer
+		return env.Update(symbol, func(value Expr) Expr {
+			integer, ok := value.(*Integer)
+			if !ok {
+				panic(NewRuntimeError("inc! requires an integer, but got " + printable(value)))
+			}
+			return NewInteger(integer.Value() + 1)
+		})
 	},
 
-	"load": func(env *Env, args *Cell) Expr {
-		args.Each(func(expr Expr) {
-			if filename, ok := expr.(*String); ok {
-				file, err := os.Open(filename.value)
-				if nil != err {
-					panic(NewRuntimeError("Cannot load: " + filename.String()))
-				}
-				defer file.Close()
-				env.Load(file)
-			} else {
-				panic(NewRuntimeError("Cannot load: " + expr.String()))
-			}
+	"set!": func(env *Env, args *Cell) Expr {
+		symbol, ok := args.Car().(*Symbol)
+		if !ok {
+			panic(NewRuntimeError("set! requires a symbol"))
+		}
+		value := env.Eval(args.Cadr())
+		return env.Update(symbol, func(Expr) Expr {
+			return value
 		})
+	},
+
+	// (swap! symbol f args...) rebinds symbol to (f value args...).  If
+	// another goroutine changes symbol while f runs, f is called again with
+	// the new value.
+	"swap!": func(env *Env, args *Cell) Expr {
+		symbol, ok := args.Car().(*Symbol)
+		if !ok {
+			panic(NewRuntimeError("swap! requires a symbol"))
+		}
+		function := functionArg("swap!", env.Eval(args.Cadr()))
+		rest := env.EvalEach(args.Cdr().Cdr())
+		for {
+			old := env.EvalSymbol(symbol)
+			value := function.call(env, NewCell(old, rest))
+			if env.CompareAndSwap(symbol, old, value) {
+				return value
+			}
+		}
+	},
+
+	"set-macro-character": func(env *Env, args *Cell) Expr {
+		key, ok := env.Eval(args.Car()).(*String)
+		if !ok {
+			panic(NewRuntimeError("set-macro-character requires a string key"))
+		}
+		function, ok := env.Eval(args.Cadr()).(*Function)
+		if !ok {
+			panic(NewRuntimeError("set-macro-character requires a function"))
+		}
+		env.table.setFunction(key.value, function)
 		return True
 	},
+
+	// (try expr handler) returns the value of expr, or if evaluating it
+	// raises an error, the result of calling handler with the error.
+	"try": func(env *Env, args *Cell) (result Expr) {
+		handler := functionArg("try", env.Eval(args.Cadr()))
+		b := env.currentBudget()
+		mark := b.mark()
+		defer func() {
+			if r := recover(); r != nil {
+				switch err := r.(type) {
+				case *RuntimeError, *SyntaxError:
+					if err == ErrMemoryLimitExceeded {
+						b.release(mark)
+					}
+					result = handler.call(env, NewCell(err.(Expr), Empty))
+				default:
+					panic(r)
+				}
+			}
+		}()
+		return env.Eval(args.Car())
+	},
 }